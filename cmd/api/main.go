@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
@@ -10,10 +12,17 @@ import (
 	"log"
 	"vinak/internal/config"
 	"vinak/internal/handlers"
+	"vinak/internal/middleware"
 	"vinak/internal/models"
 	"vinak/internal/services"
+	"vinak/internal/workers"
+	"vinak/pkg/constants"
 	"vinak/pkg/email"
+	"vinak/pkg/moderation"
+	"vinak/pkg/money"
 	"vinak/pkg/payment"
+	"vinak/pkg/rates"
+	"vinak/pkg/storage"
 	"vinak/pkg/telegram"
 )
 
@@ -30,7 +39,7 @@ func main() {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	if err := db.AutoMigrate(&models.User{}, &models.Payment{}, &models.PaymentLog{}); err != nil {
+	if err := models.Migrate(db); err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
 
@@ -44,39 +53,161 @@ func main() {
 	}
 
 	otpService := services.NewOTPService(rdb)
+	idempotencyService := services.NewIdempotencyService(rdb, cfg.IdempotencyTTL, cfg.IdempotencyLockTTL)
 	emailService := email.NewEmailService(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUser, cfg.SMTPPass)
 
 	// Initialize payment services
-	nowpaymentsService := payment.NewNowPaymentsService(cfg.NowPaymentsAPIKey)
-	paypalService, err := payment.NewPayPalService(cfg.PayPalClientID, cfg.PayPalClientSecret, cfg.PayPalMode)
+	nowpaymentsService := payment.NewNowPaymentsService(cfg.NowPaymentsAPIKey, cfg.NowPaymentsIPNSecret)
+	paypalService, err := payment.NewPayPalService(cfg.PayPalClientID, cfg.PayPalClientSecret, cfg.PayPalMode, cfg.PayPalWebhookID)
 	if err != nil {
 		log.Fatalf("Failed to initialize PayPal service: %v", err)
 	}
 	zarinpalService := payment.NewZarinpalService(cfg.ZarinpalMerchantID, cfg.ZarinpalSandbox)
 
+	gateways := payment.NewRegistry(
+		payment.NewZarinpalGateway(zarinpalService, cfg.APIBaseURL+"/api/payments/zarinpal/callback", cfg.ZarinpalCurrency),
+		payment.NewPayPalGateway(paypalService, cfg.PayPalReturnURL, cfg.PayPalCancelURL),
+		payment.NewNowPaymentsGateway(nowpaymentsService, cfg.APIBaseURL+"/api/payments/nowpayments/callback", cfg.NowPaymentsCompletedStatuses),
+	)
+
 	// Initialize Telegram service
 	telegramService, err := telegram.NewTelegramService(cfg.TelegramToken, cfg.TelegramChatID)
 	if err != nil {
 		log.Fatalf("Failed to initialize Telegram service: %v", err)
 	}
 
-	userHandler := handlers.NewUserHandler(db, otpService, emailService)
-	paymentHandler := handlers.NewPaymentHandler(db, nowpaymentsService, paypalService, zarinpalService, telegramService)
+	donationFeedService := services.NewDonationFeedService(rdb)
+	notificationService := services.NewNotificationService(db, telegramService, donationFeedService)
+	paymentService := services.NewPaymentService(db, gateways)
+	paymentEventService := services.NewPaymentEventService(rdb)
+	paymentService.OnTransition(paymentEventService.HandleTransition)
+	paymentService.OnTransition(notificationService.HandleTransition)
+
+	tierService := services.NewTierService(db)
+	paymentService.OnTransition(tierService.HandleTransition)
+
+	leaderboardService := services.NewLeaderboardService(db, rdb, cfg.ReferenceCurrency)
+	paymentService.OnTransition(leaderboardService.HandleTransition)
+	go func() {
+		if err := leaderboardService.EnsureBuilt(); err != nil {
+			log.Printf("Failed to build the leaderboard in Redis: %v", err)
+		}
+	}()
+
+	giftService := services.NewGiftService(db, paymentService, emailService, tierService, leaderboardService, cfg.SiteURL)
+	paymentService.OnTransition(giftService.HandleTransition)
+
+	moderationService := services.NewModerationService(db, moderation.NewFilter(cfg.BlockedWords), cfg.MessageAutoApprove, paymentService, leaderboardService)
+
+	reconciler := workers.NewReconciler(db, paymentService, cfg.ReconcileInterval, cfg.ReconcileMinAge)
+	go reconciler.Run(context.Background())
+
+	expirer := workers.NewExpirer(db, paymentService, cfg.ExpiryInterval, cfg.PaymentExpiry)
+	go expirer.Run(context.Background())
+
+	var rateProvider rates.Provider
+	switch cfg.ExchangeRateProvider {
+	case constants.RateProviderStatic:
+		rateProvider = rates.NewStaticProvider(cfg.ExchangeRates)
+	case constants.RateProviderHTTP:
+		rateProvider = rates.NewHTTPProvider(cfg.ExchangeRateURL)
+	default:
+		log.Fatalf("Unknown exchange rate provider: %s", cfg.ExchangeRateProvider)
+	}
+	if _, err := money.Exponent(cfg.ReferenceCurrency); err != nil {
+		log.Fatalf("Invalid reference currency: %v", err)
+	}
+
+	rateRefresher := workers.NewRateRefresher(db, rateProvider, leaderboardService, cfg.ReferenceCurrency, money.Currencies(), cfg.ExchangeRateInterval)
+	go rateRefresher.Run(context.Background())
+
+	campaignService := services.NewCampaignService(db)
+	promoService := services.NewPromoService(db, cfg.MinDonationAmounts)
+
+	var assetStore storage.Store
+	switch cfg.AssetStore {
+	case constants.AssetStoreLocal:
+		assetStore = storage.NewLocalStore(cfg.AssetDir)
+	case constants.AssetStoreS3:
+		assetStore, err = storage.NewS3Store(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3PathStyle, cfg.DownloadURLTTL)
+		if err != nil {
+			log.Fatalf("Failed to initialize S3 asset store: %v", err)
+		}
+	default:
+		log.Fatalf("Unknown asset store: %s", cfg.AssetStore)
+	}
+
+	downloadSecret := signingSecret("DOWNLOAD_SECRET", cfg.DownloadSecret, "download links")
+	downloadService := services.NewDownloadService(db, assetStore, cfg.APIBaseURL, downloadSecret, cfg.DownloadURLTTL, cfg.DownloadLimit)
+
+	streamTokens := services.NewStreamTokenService(signingSecret("STREAM_TOKEN_SECRET", cfg.StreamTokenSecret, "payment stream tokens"), cfg.StreamTokenTTL)
+
+	userHandler := handlers.NewUserHandler(db, otpService, emailService, leaderboardService, giftService)
+	leaderboardHandler := handlers.NewLeaderboardHandler(leaderboardService, campaignService)
+	campaignHandler := handlers.NewCampaignHandler(db, campaignService)
+	tierHandler := handlers.NewTierHandler(db, tierService)
+	downloadHandler := handlers.NewDownloadHandler(db, downloadService)
+	promoCodeHandler := handlers.NewPromoCodeHandler(db, promoService)
+	overlayHandler := handlers.NewOverlayHandler(db)
+	moderationHandler := handlers.NewModerationHandler(db, moderationService)
+	streamHandler := handlers.NewStreamHandler(db, paymentEventService, donationFeedService, streamTokens)
+	paymentHandler := handlers.NewPaymentHandler(db, gateways, paymentService, moderationService, campaignService, promoService, giftService, cfg.PaymentSuccessURL, cfg.PaymentFailureURL, cfg.PaymentPendingURL, cfg.PaymentRefreshAfter)
 
 	r := gin.Default()
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://127.0.0.1:5502", "https://ak47album.com"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", constants.HeaderIdempotencyKey},
 		AllowCredentials: true,
 	}))
 	r.POST("/api/send-otp", userHandler.SendOTP)
 	r.POST("/api/verify-otp", userHandler.VerifyOTPAndCreateUser)
 
-	r.POST("/api/payments", paymentHandler.CreatePayment)
-	r.GET("/api/top-users", paymentHandler.GetTopUsers)
-	r.POST("/api/payments/paypal/callback", paymentHandler.HandlePayPalCallback)
-	r.POST("/api/payments/nowpayments/callback", paymentHandler.HandleNowPaymentsCallback)
+	r.POST("/api/payments", middleware.Idempotency(idempotencyService), paymentHandler.CreatePayment)
+	r.GET("/api/payments/:id", middleware.Auth(db), paymentHandler.GetPayment)
+	r.POST("/api/payments/:id/stream-token", middleware.Auth(db), streamHandler.CreateStreamToken)
+	r.GET("/api/payments/:id/events", middleware.StreamAuth(db, streamTokens), streamHandler.PaymentEvents)
+	r.GET("/api/top-users", leaderboardHandler.GetTopUsers)
+	r.GET("/api/campaigns/:slug", campaignHandler.GetCampaign)
+	r.GET("/api/campaigns/:slug/top-users", leaderboardHandler.GetCampaignTopUsers)
+	r.GET("/api/downloads/:id", downloadHandler.Download)
+	r.GET("/api/overlay/donations", streamHandler.DonationFeed)
+	r.GET("/api/payments/paypal/return", paymentHandler.HandlePayPalReturn)
+	r.POST("/api/payments/paypal/webhook", paymentHandler.HandleNotification(constants.PaymentGatewayPayPal))
+	r.POST("/api/payments/nowpayments/callback", paymentHandler.HandleNotification(constants.PaymentGatewayNowPayments))
+	r.GET("/api/payments/zarinpal/callback", paymentHandler.HandleZarinpalCallback)
+
+	me := r.Group("/api/me", middleware.Auth(db))
+	me.GET("/payments", paymentHandler.ListMyPayments)
+	me.PUT("/privacy", userHandler.UpdatePrivacy)
+	me.GET("/tier", tierHandler.GetMyTier)
+	me.GET("/downloads", downloadHandler.ListMyDownloads)
+
+	admin := r.Group("/api/admin", middleware.Auth(db), middleware.RequireAdmin())
+	admin.POST("/payments/:id/refund", paymentHandler.RefundPayment)
+	admin.GET("/messages", moderationHandler.ListMessages)
+	admin.POST("/messages/:id/approve", moderationHandler.ApproveMessage)
+	admin.POST("/messages/:id/reject", moderationHandler.RejectMessage)
+	admin.GET("/campaigns", campaignHandler.ListCampaigns)
+	admin.POST("/campaigns", campaignHandler.CreateCampaign)
+	admin.PUT("/campaigns/:id", campaignHandler.UpdateCampaign)
+	admin.GET("/tiers", tierHandler.ListTiers)
+	admin.POST("/tiers", tierHandler.CreateTier)
+	admin.PUT("/tiers/:id", tierHandler.UpdateTier)
+	admin.GET("/tiers/awards/export", tierHandler.ExportTierAwards)
+	admin.PUT("/tiers/awards/:id", tierHandler.UpdateTierAward)
+	admin.GET("/promo-codes", promoCodeHandler.ListPromoCodes)
+	admin.POST("/promo-codes", promoCodeHandler.CreatePromoCode)
+	admin.GET("/promo-codes/:id", promoCodeHandler.GetPromoCode)
+	admin.PUT("/promo-codes/:id", promoCodeHandler.UpdatePromoCode)
+	admin.DELETE("/promo-codes/:id", promoCodeHandler.DeletePromoCode)
+	admin.GET("/assets", downloadHandler.ListAssets)
+	admin.POST("/assets", downloadHandler.CreateAsset)
+	admin.DELETE("/assets/:id", downloadHandler.DeleteAsset)
+	admin.GET("/overlays", overlayHandler.ListOverlays)
+	admin.POST("/overlays", overlayHandler.CreateOverlay)
+	admin.PUT("/overlays/:id", overlayHandler.UpdateOverlay)
+	admin.DELETE("/overlays/:id", overlayHandler.DeleteOverlay)
 
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -88,3 +219,18 @@ func main() {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// signingSecret returns the configured secret, or a random one that won't survive a
+// restart, invalidating whatever was signed with it
+func signingSecret(name, value, signs string) string {
+	if value != "" {
+		return value
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("Failed to generate %s: %v", name, err)
+	}
+	log.Printf("%s is not set, %s will stop working on restart", name, signs)
+	return hex.EncodeToString(secret)
+}
@@ -0,0 +1,41 @@
+// Command rebuild-leaderboard recomputes the leaderboards materialized in Redis from the
+// payments in the database. Run it after Redis lost data or if the lists look wrong.
+package main
+
+import (
+	"context"
+	"log"
+	"vinak/internal/config"
+	"vinak/internal/services"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func main() {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	dsn := "host=" + cfg.DBHost + " user=" + cfg.DBUser + " password=" + cfg.DBPassword +
+		" dbname=" + cfg.DBName + " port=" + cfg.DBPort + " sslmode=disable"
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisURL,
+		Password: cfg.RedisPassword,
+	})
+	if _, err := rdb.Ping(context.Background()).Result(); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+
+	if err := services.NewLeaderboardService(db, rdb, cfg.ReferenceCurrency).Rebuild(); err != nil {
+		log.Fatalf("Failed to rebuild the leaderboard: %v", err)
+	}
+	log.Println("Leaderboard rebuilt")
+}
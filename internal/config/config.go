@@ -1,33 +1,77 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	DBHost             string
-	DBPort             string
-	DBUser             string
-	DBPassword         string
-	DBName             string
-	RedisURL           string
-	RedisPassword      string
-	SMTPHost           string
-	SMTPPort           string
-	SMTPUser           string
-	SMTPPass           string
-	ZarinpalMerchantID string
-	ZarinpalSandbox    bool
-	TelegramToken      string
-	TelegramChatID     int64
-	ServerPort         string
-	NowPaymentsAPIKey  string
-	PayPalClientID     string
-	PayPalClientSecret string
-	PayPalMode         string
+	DBHost                       string
+	DBPort                       string
+	DBUser                       string
+	DBPassword                   string
+	DBName                       string
+	RedisURL                     string
+	RedisPassword                string
+	SMTPHost                     string
+	SMTPPort                     string
+	SMTPUser                     string
+	SMTPPass                     string
+	ZarinpalMerchantID           string
+	ZarinpalSandbox              bool
+	ZarinpalCurrency             string
+	TelegramToken                string
+	TelegramChatID               int64
+	ServerPort                   string
+	NowPaymentsAPIKey            string
+	NowPaymentsIPNSecret         string
+	NowPaymentsCompletedStatuses []string
+	PayPalClientID               string
+	PayPalClientSecret           string
+	PayPalMode                   string
+	PayPalWebhookID              string
+	PayPalReturnURL              string
+	PayPalCancelURL              string
+	APIBaseURL                   string
+	PaymentSuccessURL            string
+	PaymentFailureURL            string
+	PaymentPendingURL            string
+	IdempotencyTTL               time.Duration
+	IdempotencyLockTTL           time.Duration
+	ReconcileInterval            time.Duration
+	ReconcileMinAge              time.Duration
+	ExpiryInterval               time.Duration
+	PaymentExpiry                map[string]time.Duration
+	PaymentRefreshAfter          time.Duration
+	MessageAutoApprove           bool
+	BlockedWords                 []string
+	ReferenceCurrency            string
+	ExchangeRateProvider         string
+	ExchangeRateURL              string
+	ExchangeRates                map[string]float64
+	ExchangeRateInterval         time.Duration
+	AssetStore                   string
+	AssetDir                     string
+	S3Endpoint                   string
+	S3Region                     string
+	S3Bucket                     string
+	S3AccessKey                  string
+	S3SecretKey                  string
+	S3PathStyle                  bool
+	DownloadSecret               string
+	DownloadURLTTL               time.Duration
+	DownloadLimit                int
+	MinDonationAmounts           map[string]int64
+	SiteURL                      string
+	StreamTokenSecret            string
+	StreamTokenTTL               time.Duration
 }
 
 func LoadConfig() (*Config, error) {
@@ -41,28 +85,184 @@ func LoadConfig() (*Config, error) {
 		return nil, err
 	}
 
+	idempotencyTTL, err := getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	// IDEMPOTENCY_LOCK_TTL bounds how long a request can hold its key, it should outlast the
+	// slowest gateway call
+	idempotencyLockTTL, err := getEnvDuration("IDEMPOTENCY_LOCK_TTL", 2*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	reconcileInterval, err := getEnvDuration("RECONCILE_INTERVAL", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	reconcileMinAge, err := getEnvDuration("RECONCILE_MIN_AGE", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	expiryInterval, err := getEnvDuration("EXPIRY_INTERVAL", 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	paymentRefreshAfter, err := getEnvDuration("PAYMENT_REFRESH_AFTER", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	exchangeRateInterval, err := getEnvDuration("EXCHANGE_RATE_INTERVAL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	streamTokenTTL, err := getEnvDuration("STREAM_TOKEN_TTL", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadURLTTL, err := getEnvDuration("DOWNLOAD_URL_TTL", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	// DOWNLOAD_LIMIT caps how often each supporter can download an asset, 0 for no limit
+	downloadLimit, err := strconv.Atoi(getEnv("DOWNLOAD_LIMIT", "0"))
+	if err != nil {
+		return nil, err
+	}
+
+	// EXCHANGE_RATES holds the static provider's rates as currency=rate pairs, e.g. irr=0.0000024
+	exchangeRates := make(map[string]float64)
+	for _, pair := range getEnvList("EXCHANGE_RATES") {
+		currency, value, _ := strings.Cut(pair, "=")
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exchange rate %q: %w", pair, err)
+		}
+		exchangeRates[strings.ToLower(strings.TrimSpace(currency))] = rate
+	}
+
+	// MIN_DONATION_AMOUNTS holds the smallest donation per currency as currency=amount
+	// pairs, e.g. usd=1,irr=500000. Promo codes can change it.
+	minDonationAmounts := make(map[string]int64)
+	for _, pair := range getEnvList("MIN_DONATION_AMOUNTS") {
+		currency, value, _ := strings.Cut(pair, "=")
+		currency = strings.ToLower(strings.TrimSpace(currency))
+		amount, err := money.Parse(strings.TrimSpace(value), currency)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minimum donation amount %q: %w", pair, err)
+		}
+		minDonationAmounts[currency] = amount.Amount
+	}
+
+	paymentExpiry := make(map[string]time.Duration)
+	for gateway, fallback := range map[string]time.Duration{
+		constants.PaymentGatewayZarinpal:    30 * time.Minute,
+		constants.PaymentGatewayPayPal:      3 * time.Hour,
+		constants.PaymentGatewayNowPayments: 24 * time.Hour,
+	} {
+		paymentExpiry[gateway], err = getEnvDuration("PAYMENT_EXPIRY_"+strings.ToUpper(gateway), fallback)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	apiBaseURL := getEnv("API_BASE_URL", "https://ak47album.com")
+	paymentFailureURL := getEnv("PAYMENT_FAILURE_URL", "https://ak47album.com/payment/failure")
+
 	config := &Config{
-		DBHost:             os.Getenv("DB_HOST"),
-		DBPort:             os.Getenv("DB_PORT"),
-		DBUser:             os.Getenv("DB_USER"),
-		DBPassword:         os.Getenv("DB_PASSWORD"),
-		DBName:             os.Getenv("DB_NAME"),
-		RedisURL:           os.Getenv("REDIS_URL"),
-		RedisPassword:      os.Getenv("REDIS_PASSWORD"),
-		SMTPHost:           os.Getenv("SMTP_HOST"),
-		SMTPPort:           os.Getenv("SMTP_PORT"),
-		SMTPUser:           os.Getenv("SMTP_USER"),
-		SMTPPass:           os.Getenv("SMTP_PASS"),
-		ZarinpalMerchantID: os.Getenv("ZARINPAL_MERCHANT_ID"),
-		ZarinpalSandbox:    os.Getenv("ZARINPAL_SANDBOX") == "true",
-		TelegramToken:      os.Getenv("TELEGRAM_TOKEN"),
-		TelegramChatID:     chatID,
-		ServerPort:         os.Getenv("SERVER_PORT"),
-		NowPaymentsAPIKey:  os.Getenv("NOWPAYMENTS_API_KEY"),
-		PayPalClientID:     os.Getenv("PAYPAL_CLIENT_ID"),
-		PayPalClientSecret: os.Getenv("PAYPAL_CLIENT_SECRET"),
-		PayPalMode:         os.Getenv("PAYPAL_MODE"),
+		DBHost:                       os.Getenv("DB_HOST"),
+		DBPort:                       os.Getenv("DB_PORT"),
+		DBUser:                       os.Getenv("DB_USER"),
+		DBPassword:                   os.Getenv("DB_PASSWORD"),
+		DBName:                       os.Getenv("DB_NAME"),
+		RedisURL:                     os.Getenv("REDIS_URL"),
+		RedisPassword:                os.Getenv("REDIS_PASSWORD"),
+		SMTPHost:                     os.Getenv("SMTP_HOST"),
+		SMTPPort:                     os.Getenv("SMTP_PORT"),
+		SMTPUser:                     os.Getenv("SMTP_USER"),
+		SMTPPass:                     os.Getenv("SMTP_PASS"),
+		ZarinpalMerchantID:           os.Getenv("ZARINPAL_MERCHANT_ID"),
+		ZarinpalSandbox:              os.Getenv("ZARINPAL_SANDBOX") == "true",
+		ZarinpalCurrency:             strings.ToLower(getEnv("ZARINPAL_CURRENCY", constants.CurrencyIRR)),
+		TelegramToken:                os.Getenv("TELEGRAM_TOKEN"),
+		TelegramChatID:               chatID,
+		ServerPort:                   os.Getenv("SERVER_PORT"),
+		NowPaymentsAPIKey:            os.Getenv("NOWPAYMENTS_API_KEY"),
+		NowPaymentsIPNSecret:         os.Getenv("NOWPAYMENTS_IPN_SECRET"),
+		NowPaymentsCompletedStatuses: getEnvList("NOWPAYMENTS_COMPLETED_STATUSES"),
+		PayPalClientID:               os.Getenv("PAYPAL_CLIENT_ID"),
+		PayPalClientSecret:           os.Getenv("PAYPAL_CLIENT_SECRET"),
+		PayPalMode:                   os.Getenv("PAYPAL_MODE"),
+		PayPalWebhookID:              os.Getenv("PAYPAL_WEBHOOK_ID"),
+		PayPalReturnURL:              getEnv("PAYPAL_RETURN_URL", apiBaseURL+"/api/payments/paypal/return"),
+		PayPalCancelURL:              getEnv("PAYPAL_CANCEL_URL", paymentFailureURL),
+		APIBaseURL:                   apiBaseURL,
+		PaymentSuccessURL:            getEnv("PAYMENT_SUCCESS_URL", "https://ak47album.com/payment/success"),
+		PaymentFailureURL:            paymentFailureURL,
+		PaymentPendingURL:            getEnv("PAYMENT_PENDING_URL", "https://ak47album.com/payment/pending"),
+		IdempotencyTTL:               idempotencyTTL,
+		IdempotencyLockTTL:           idempotencyLockTTL,
+		ReconcileInterval:            reconcileInterval,
+		ReconcileMinAge:              reconcileMinAge,
+		ExpiryInterval:               expiryInterval,
+		PaymentExpiry:                paymentExpiry,
+		PaymentRefreshAfter:          paymentRefreshAfter,
+		MessageAutoApprove:           getEnv("MESSAGE_AUTO_APPROVE", "true") == "true",
+		BlockedWords:                 getEnvList("BLOCKED_WORDS"),
+		ReferenceCurrency:            strings.ToLower(getEnv("REFERENCE_CURRENCY", constants.CurrencyUSD)),
+		ExchangeRateProvider:         getEnv("EXCHANGE_RATE_PROVIDER", constants.RateProviderStatic),
+		ExchangeRateURL:              getEnv("EXCHANGE_RATE_URL", "https://open.er-api.com/v6/latest/{base}"),
+		ExchangeRates:                exchangeRates,
+		ExchangeRateInterval:         exchangeRateInterval,
+		AssetStore:                   getEnv("ASSET_STORE", constants.AssetStoreLocal),
+		AssetDir:                     getEnv("ASSET_DIR", "assets"),
+		S3Endpoint:                   os.Getenv("S3_ENDPOINT"),
+		S3Region:                     getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:                     os.Getenv("S3_BUCKET"),
+		S3AccessKey:                  os.Getenv("S3_ACCESS_KEY"),
+		S3SecretKey:                  os.Getenv("S3_SECRET_KEY"),
+		S3PathStyle:                  os.Getenv("S3_PATH_STYLE") == "true",
+		DownloadSecret:               os.Getenv("DOWNLOAD_SECRET"),
+		DownloadURLTTL:               downloadURLTTL,
+		DownloadLimit:                downloadLimit,
+		MinDonationAmounts:           minDonationAmounts,
+		SiteURL:                      getEnv("SITE_URL", "https://ak47album.com"),
+		StreamTokenSecret:            os.Getenv("STREAM_TOKEN_SECRET"),
+		StreamTokenTTL:               streamTokenTTL,
 	}
 
 	return config, nil
 }
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+	"vinak/internal/models"
+	"vinak/internal/services"
+	"vinak/pkg/constants"
+	"vinak/pkg/errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var campaignSlugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+type CampaignHandler struct {
+	db              *gorm.DB
+	campaignService *services.CampaignService
+}
+
+func NewCampaignHandler(db *gorm.DB, campaignService *services.CampaignService) *CampaignHandler {
+	return &CampaignHandler{
+		db:              db,
+		campaignService: campaignService,
+	}
+}
+
+type CampaignRequest struct {
+	Slug        string `json:"slug" binding:"required,max=100"`
+	Title       string `json:"title" binding:"required,max=255"`
+	Description string `json:"description"`
+	// Targets maps a currency to the goal in it, as a decimal
+	Targets  map[string]json.Number `json:"targets"`
+	StartsAt *time.Time             `json:"starts_at"`
+	EndsAt   *time.Time             `json:"ends_at"`
+	Status   string                 `json:"status"`
+}
+
+type CampaignResponse struct {
+	ID                 uint                   `json:"id"`
+	Slug               string                 `json:"slug"`
+	Title              string                 `json:"title"`
+	Description        string                 `json:"description"`
+	Targets            map[string]json.Number `json:"targets"`
+	StartsAt           time.Time              `json:"starts_at"`
+	EndsAt             *time.Time             `json:"ends_at"`
+	Status             string                 `json:"status"`
+	AcceptingDonations bool                   `json:"accepting_donations"`
+	CreatedAt          time.Time              `json:"created_at"`
+}
+
+type CampaignProgressResponse struct {
+	Currency string      `json:"currency"`
+	Raised   json.Number `json:"raised"`
+	Target   json.Number `json:"target,omitempty"`
+	// Percent of the target raised, to one decimal. Omitted without a target.
+	Percent *float64 `json:"percent,omitempty"`
+}
+
+func newCampaignResponse(campaign *models.Campaign) CampaignResponse {
+	return CampaignResponse{
+		ID:                 campaign.ID,
+		Slug:               campaign.Slug,
+		Title:              campaign.Title,
+		Description:        campaign.Description,
+		Targets:            decimalAmounts(campaign.Targets),
+		StartsAt:           campaign.StartsAt,
+		EndsAt:             campaign.EndsAt,
+		Status:             campaign.Status,
+		AcceptingDonations: campaign.AcceptsDonations(time.Now()),
+		CreatedAt:          campaign.CreatedAt,
+	}
+}
+
+// GetCampaign returns a public campaign with how much it raised towards its target in
+// each currency
+func (h *CampaignHandler) GetCampaign(c *gin.Context) {
+	campaign, err := h.campaignService.Public(c.Param("slug"))
+	if stderrors.Is(err, services.ErrCampaignNotFound) {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrCampaignNotFound))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetCampaigns))
+		return
+	}
+
+	progress, supporters, err := h.campaignService.Progress(campaign)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetCampaigns))
+		return
+	}
+
+	response := make([]CampaignProgressResponse, len(progress))
+	for i, entry := range progress {
+		response[i] = CampaignProgressResponse{
+			Currency: entry.Raised.Currency,
+			Raised:   entry.Raised.Decimal(),
+		}
+		if entry.Target.Amount > 0 {
+			percent := math.Round(float64(entry.Raised.Amount)*1000/float64(entry.Target.Amount)) / 10
+			response[i].Target = entry.Target.Decimal()
+			response[i].Percent = &percent
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"campaign":   newCampaignResponse(campaign),
+		"progress":   response,
+		"supporters": supporters,
+	})
+}
+
+func (h *CampaignHandler) ListCampaigns(c *gin.Context) {
+	var campaigns []models.Campaign
+	if err := h.db.Order("starts_at DESC").Find(&campaigns).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetCampaigns))
+		return
+	}
+
+	response := make([]CampaignResponse, len(campaigns))
+	for i := range campaigns {
+		response[i] = newCampaignResponse(&campaigns[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{"campaigns": response})
+}
+
+func (h *CampaignHandler) CreateCampaign(c *gin.Context) {
+	campaign := models.Campaign{
+		Status:   constants.CampaignStatusDraft,
+		StartsAt: time.Now(),
+	}
+	if !h.bindCampaign(c, &campaign) {
+		return
+	}
+
+	if err := h.db.Create(&campaign).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSaveCampaign))
+		return
+	}
+
+	c.JSON(http.StatusCreated, newCampaignResponse(&campaign))
+}
+
+func (h *CampaignHandler) UpdateCampaign(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrCampaignNotFound))
+		return
+	}
+
+	var campaign models.Campaign
+	if err := h.db.First(&campaign, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrCampaignNotFound))
+		return
+	}
+
+	if !h.bindCampaign(c, &campaign) {
+		return
+	}
+
+	if err := h.db.Save(&campaign).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSaveCampaign))
+		return
+	}
+
+	c.JSON(http.StatusOK, newCampaignResponse(&campaign))
+}
+
+// bindCampaign applies a CampaignRequest to campaign, writing a 400 or 409 response and
+// returning false if it is invalid. A missing status or start date is left unchanged.
+func (h *CampaignHandler) bindCampaign(c *gin.Context, campaign *models.Campaign) bool {
+	var req CampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, err.Error()))
+		return false
+	}
+
+	if !campaignSlugPattern.MatchString(req.Slug) {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidCampaignSlug))
+		return false
+	}
+
+	var taken int64
+	if err := h.db.Model(&models.Campaign{}).Where("slug = ? AND id <> ?", req.Slug, campaign.ID).Count(&taken).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSaveCampaign))
+		return false
+	}
+	if taken > 0 {
+		c.JSON(http.StatusConflict, errors.NewAPIError(http.StatusConflict, constants.ErrCampaignSlugTaken))
+		return false
+	}
+
+	if req.Status != "" && !models.IsCampaignStatus(req.Status) {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidCampaignStatus))
+		return false
+	}
+
+	targets, err := parseAmounts(req.Targets)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidAmount))
+		return false
+	}
+
+	startsAt := campaign.StartsAt
+	if req.StartsAt != nil {
+		startsAt = *req.StartsAt
+	}
+	if req.EndsAt != nil && !req.EndsAt.After(startsAt) {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidCampaignDates))
+		return false
+	}
+
+	campaign.Slug = req.Slug
+	campaign.Title = req.Title
+	campaign.Description = req.Description
+	campaign.Targets = targets
+	campaign.StartsAt = startsAt
+	campaign.EndsAt = req.EndsAt
+	if req.Status != "" {
+		campaign.Status = req.Status
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB returns a migrated in-memory database private to the test. A single
+// connection serializes transactions the way row locks would on Postgres.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", url.PathEscape(t.Name()))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := models.Migrate(db); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+	return db
+}
+
+func createTestUser(t *testing.T, db *gorm.DB, name string) *models.User {
+	t.Helper()
+
+	user := models.User{
+		Email:             name + "@example.com",
+		InstagramID:       name,
+		Name:              name,
+		APIKey:            "key-" + name,
+		VerificationToken: "token-" + name,
+		Privacy:           constants.PrivacyPublic,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user %s: %v", name, err)
+	}
+	return &user
+}
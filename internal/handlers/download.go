@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	stderrors "errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"vinak/internal/middleware"
+	"vinak/internal/models"
+	"vinak/internal/services"
+	"vinak/pkg/constants"
+	"vinak/pkg/errors"
+	"vinak/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type DownloadHandler struct {
+	db              *gorm.DB
+	downloadService *services.DownloadService
+}
+
+func NewDownloadHandler(db *gorm.DB, downloadService *services.DownloadService) *DownloadHandler {
+	return &DownloadHandler{
+		db:              db,
+		downloadService: downloadService,
+	}
+}
+
+type AssetRequest struct {
+	Title       string `json:"title" binding:"required,max=255"`
+	FileName    string `json:"file_name" binding:"required,max=255"`
+	Key         string `json:"key" binding:"required,max=1024"`
+	ContentType string `json:"content_type" binding:"max=100"`
+	Size        int64  `json:"size" binding:"min=0"`
+	Position    int    `json:"position"`
+}
+
+type AssetResponse struct {
+	ID          uint      `json:"id"`
+	Title       string    `json:"title"`
+	FileName    string    `json:"file_name"`
+	Key         string    `json:"key"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Position    int       `json:"position"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type DownloadResponse struct {
+	AssetID          uint       `json:"asset_id"`
+	Title            string     `json:"title"`
+	FileName         string     `json:"file_name"`
+	ContentType      string     `json:"content_type"`
+	Size             int64      `json:"size"`
+	URL              string     `json:"url"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	DownloadCount    int        `json:"download_count"`
+	LastDownloadedAt *time.Time `json:"last_downloaded_at"`
+	// Remaining downloads, omitted when there is no limit
+	Remaining *int `json:"remaining,omitempty"`
+}
+
+func newAssetResponse(asset *models.Asset) AssetResponse {
+	return AssetResponse{
+		ID:          asset.ID,
+		Title:       asset.Title,
+		FileName:    asset.FileName,
+		Key:         asset.Key,
+		ContentType: asset.ContentType,
+		Size:        asset.Size,
+		Position:    asset.Position,
+		CreatedAt:   asset.CreatedAt,
+	}
+}
+
+// ListMyDownloads returns a freshly signed download link for every album asset, with how
+// often the caller downloaded it. Only users credited with a completed donation get links.
+func (h *DownloadHandler) ListMyDownloads(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+
+	eligible, err := h.downloadService.Eligible(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetDownloads))
+		return
+	}
+	if !eligible {
+		c.JSON(http.StatusForbidden, errors.NewAPIError(http.StatusForbidden, constants.ErrDownloadNotAllowed))
+		return
+	}
+
+	assets, err := h.downloadService.Assets()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetDownloads))
+		return
+	}
+
+	downloads, err := h.downloadService.Downloads(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetDownloads))
+		return
+	}
+
+	limit := h.downloadService.Limit()
+	response := make([]DownloadResponse, len(assets))
+	for i, asset := range assets {
+		link := h.downloadService.Sign(asset.ID, user.ID)
+		response[i] = DownloadResponse{
+			AssetID:     asset.ID,
+			Title:       asset.Title,
+			FileName:    asset.FileName,
+			ContentType: asset.ContentType,
+			Size:        asset.Size,
+			URL:         link.URL,
+			ExpiresAt:   link.ExpiresAt,
+		}
+		if download, ok := downloads[asset.ID]; ok {
+			response[i].DownloadCount = download.Count
+			response[i].LastDownloadedAt = &download.LastDownloadedAt
+		}
+		if limit > 0 {
+			remaining := max(limit-response[i].DownloadCount, 0)
+			response[i].Remaining = &remaining
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"downloads": response})
+}
+
+// Download serves an asset for a signed link from ListMyDownloads. Local files are sent
+// directly, assets on a remote store are redirected to.
+func (h *DownloadHandler) Download(c *gin.Context) {
+	assetID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrAssetNotFound))
+		return
+	}
+	userID, err := strconv.ParseUint(c.Query(constants.QueryDownloadUser), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusForbidden, errors.NewAPIError(http.StatusForbidden, constants.ErrInvalidDownloadLink))
+		return
+	}
+	expires, err := strconv.ParseInt(c.Query(constants.QueryDownloadExpires), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusForbidden, errors.NewAPIError(http.StatusForbidden, constants.ErrInvalidDownloadLink))
+		return
+	}
+
+	if err := h.downloadService.Verify(uint(assetID), uint(userID), expires, c.Query(constants.QueryDownloadSignature)); err != nil {
+		c.JSON(http.StatusForbidden, errors.NewAPIError(http.StatusForbidden, constants.ErrInvalidDownloadLink))
+		return
+	}
+
+	asset, location, err := h.downloadService.Download(uint(assetID), uint(userID))
+	switch {
+	case stderrors.Is(err, services.ErrDownloadNotAllowed):
+		c.JSON(http.StatusForbidden, errors.NewAPIError(http.StatusForbidden, constants.ErrDownloadNotAllowed))
+		return
+	case stderrors.Is(err, services.ErrDownloadLimitReached):
+		c.JSON(http.StatusForbidden, errors.NewAPIError(http.StatusForbidden, constants.ErrDownloadLimitReached))
+		return
+	case stderrors.Is(err, services.ErrAssetNotFound):
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrAssetNotFound))
+		return
+	case err != nil:
+		log.Printf("Failed to serve asset %d to user %d: %v", assetID, userID, err)
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetDownloads))
+		return
+	}
+
+	c.Header("Cache-Control", "private, no-store")
+	if location.URL != "" {
+		c.Redirect(http.StatusFound, location.URL)
+		return
+	}
+	if asset.ContentType != "" {
+		c.Header("Content-Type", asset.ContentType)
+	}
+	c.FileAttachment(location.Path, asset.FileName)
+}
+
+func (h *DownloadHandler) ListAssets(c *gin.Context) {
+	assets, err := h.downloadService.Assets()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetDownloads))
+		return
+	}
+
+	response := make([]AssetResponse, len(assets))
+	for i := range assets {
+		response[i] = newAssetResponse(&assets[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{"assets": response})
+}
+
+// CreateAsset registers a file already uploaded to the asset store
+func (h *DownloadHandler) CreateAsset(c *gin.Context) {
+	var req AssetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	_, err := h.downloadService.Store().Locate(req.Key, req.FileName)
+	if stderrors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrAssetFileMissing))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSaveAsset))
+		return
+	}
+
+	asset := models.Asset{
+		Title:       req.Title,
+		FileName:    req.FileName,
+		Key:         req.Key,
+		ContentType: req.ContentType,
+		Size:        req.Size,
+		Position:    req.Position,
+	}
+	if err := h.db.Create(&asset).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSaveAsset))
+		return
+	}
+
+	c.JSON(http.StatusCreated, newAssetResponse(&asset))
+}
+
+// DeleteAsset removes an asset and its download counts. The file stays in the store.
+func (h *DownloadHandler) DeleteAsset(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrAssetNotFound))
+		return
+	}
+
+	var asset models.Asset
+	if err := h.db.First(&asset, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrAssetNotFound))
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("asset_id = ?", asset.ID).Delete(&models.AssetDownload{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&asset).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSaveAsset))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"vinak/internal/models"
+	"vinak/internal/services"
+	"vinak/pkg/constants"
+	"vinak/pkg/errors"
+	"vinak/pkg/money"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultLeaderboardSize = 10
+	maxLeaderboardSize     = 100
+)
+
+var (
+	errInvalidPeriod    = stderrors.New(constants.ErrInvalidPeriod)
+	errInvalidDateRange = stderrors.New(constants.ErrInvalidDateRange)
+)
+
+type LeaderboardHandler struct {
+	leaderboardService *services.LeaderboardService
+	campaignService    *services.CampaignService
+}
+
+func NewLeaderboardHandler(leaderboardService *services.LeaderboardService, campaignService *services.CampaignService) *LeaderboardHandler {
+	return &LeaderboardHandler{
+		leaderboardService: leaderboardService,
+		campaignService:    campaignService,
+	}
+}
+
+// GetTopUsers returns a ranked list per currency, or for the currency query parameter
+// only. combined=true instead returns a single list across currencies in the reference
+// currency. period is all (default), month, week or custom with from/to dates; limit
+// and offset page through each list.
+func (h *LeaderboardHandler) GetTopUsers(c *gin.Context) {
+	h.topUsers(c, nil)
+}
+
+// GetCampaignTopUsers ranks the donors of one campaign, with the same parameters as
+// GetTopUsers
+func (h *LeaderboardHandler) GetCampaignTopUsers(c *gin.Context) {
+	campaign, err := h.campaignService.Public(c.Param("slug"))
+	if stderrors.Is(err, services.ErrCampaignNotFound) {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrCampaignNotFound))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetCampaigns))
+		return
+	}
+
+	h.topUsers(c, &campaign.ID)
+}
+
+func (h *LeaderboardHandler) topUsers(c *gin.Context, campaignID *uint) {
+	query, ok := parseLeaderboardQuery(c)
+	if !ok {
+		return
+	}
+	query.CampaignID = campaignID
+
+	if h.notModified(c, query) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	var queries []services.LeaderboardQuery
+	switch currency := c.Query("currency"); {
+	case c.Query("combined") == "true":
+		query.Combined = true
+		queries = append(queries, query)
+	case currency != "":
+		if _, err := money.Exponent(currency); err != nil {
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidCurrency))
+			return
+		}
+		query.Currency = currency
+		queries = append(queries, query)
+	default:
+		for _, currency := range money.Currencies() {
+			query.Currency = currency
+			queries = append(queries, query)
+		}
+	}
+
+	supporters := []models.TopUserResponse{}
+	for _, query := range queries {
+		entries, err := h.leaderboardService.TopUsers(query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetTopUsers))
+			return
+		}
+		supporters = append(supporters, entries...)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"supporters": supporters,
+		"period":     query.Period,
+		"from":       query.From,
+		"to":         query.To,
+		"limit":      query.Limit,
+		"offset":     query.Offset,
+	})
+}
+
+// notModified sets the ETag and Last-Modified headers from when the leaderboards last
+// changed and reports whether the client's copy is still current. The ETag covers the
+// resolved period too, so a new month or week isn't answered from the previous one.
+func (h *LeaderboardHandler) notModified(c *gin.Context, query services.LeaderboardQuery) bool {
+	lastModified, err := h.leaderboardService.LastModified()
+	if err != nil {
+		log.Printf("Failed to read when the leaderboard changed: %v", err)
+		return false
+	}
+	if lastModified.IsZero() {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d|%s|%v|%v", lastModified.UnixNano(), c.Request.URL.RequestURI(), query.From, query.To)))
+	etag := `"` + hex.EncodeToString(hash[:16]) + `"`
+
+	c.Header(constants.HeaderETag, etag)
+	c.Header(constants.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", "no-cache")
+
+	if match := c.GetHeader(constants.HeaderIfNoneMatch); match != "" {
+		return match == etag
+	}
+	since, err := http.ParseTime(c.GetHeader(constants.HeaderIfModifiedSince))
+	return err == nil && !lastModified.Truncate(time.Second).After(since)
+}
+
+// parseLeaderboardQuery reads the period and paging parameters, writing a 400 response
+// and returning false if they are invalid
+func parseLeaderboardQuery(c *gin.Context) (services.LeaderboardQuery, bool) {
+	query := services.LeaderboardQuery{Limit: defaultLeaderboardSize}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLeaderboardSize {
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidLimit))
+			return query, false
+		}
+		query.Limit = limit
+	}
+
+	if value := c.Query("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidOffset))
+			return query, false
+		}
+		query.Offset = offset
+	}
+
+	query.Period = c.DefaultQuery("period", constants.LeaderboardPeriodAll)
+	from, to, err := leaderboardPeriod(query.Period, c.Query("from"), c.Query("to"), time.Now().UTC())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, err.Error()))
+		return query, false
+	}
+	query.From, query.To = from, to
+
+	return query, true
+}
+
+// leaderboardPeriod resolves a period to its bounds. The current month and week are
+// left open-ended. A date-only custom "to" includes that whole day.
+func leaderboardPeriod(period, fromValue, toValue string, now time.Time) (*time.Time, *time.Time, error) {
+	switch period {
+	case constants.LeaderboardPeriodAll:
+		return nil, nil, nil
+	case constants.LeaderboardPeriodMonth, constants.LeaderboardPeriodWeek:
+		from, _ := services.LeaderboardPeriodBounds(period, now)
+		return &from, nil, nil
+	case constants.LeaderboardPeriodCustom:
+		from, _, err := parseLeaderboardDate(fromValue)
+		if err != nil {
+			return nil, nil, errInvalidDateRange
+		}
+		to, dateOnly, err := parseLeaderboardDate(toValue)
+		if err != nil {
+			return nil, nil, errInvalidDateRange
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		if !from.Before(to) {
+			return nil, nil, errInvalidDateRange
+		}
+		return &from, &to, nil
+	default:
+		return nil, nil, errInvalidPeriod
+	}
+}
+
+func parseLeaderboardDate(value string) (time.Time, bool, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, true, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	return parsed, false, err
+}
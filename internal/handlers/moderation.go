@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	stderrors "errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"vinak/internal/middleware"
+	"vinak/internal/models"
+	"vinak/internal/services"
+	"vinak/pkg/constants"
+	"vinak/pkg/errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultMessagesPageSize = 100
+	maxMessagesPageSize     = 100
+)
+
+type ModerationHandler struct {
+	db                *gorm.DB
+	moderationService *services.ModerationService
+}
+
+func NewModerationHandler(db *gorm.DB, moderationService *services.ModerationService) *ModerationHandler {
+	return &ModerationHandler{
+		db:                db,
+		moderationService: moderationService,
+	}
+}
+
+type MessageResponse struct {
+	PaymentID     uint      `json:"payment_id"`
+	Name          string    `json:"name"`
+	InstagramID   string    `json:"instagram_id"`
+	Message       string    `json:"message"`
+	MessageStatus string    `json:"message_status"`
+	Flags         []string  `json:"flags"`
+	PaymentStatus string    `json:"payment_status"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ListMessages is the moderation queue, oldest first. It lists pending messages unless
+// another status is asked for. The cursor is the id of the last payment on the previous
+// page, returned as next_cursor.
+func (h *ModerationHandler) ListMessages(c *gin.Context) {
+	status := c.DefaultQuery("status", constants.MessageStatusPending)
+	switch status {
+	case constants.MessageStatusPending, constants.MessageStatusApproved, constants.MessageStatusRejected:
+	default:
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidMessageStatus))
+		return
+	}
+
+	limit := defaultMessagesPageSize
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxMessagesPageSize {
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	query := h.db.Model(&models.Payment{}).
+		Select("payments.*, users.name, users.instagram_id").
+		Joins("JOIN users ON users.id = payments.user_id").
+		Where("payments.message_status = ?", status)
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		id, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidCursor))
+			return
+		}
+		query = query.Where("payments.id > ?", id)
+	}
+
+	// One extra row tells whether there is another page
+	var rows []struct {
+		models.Payment
+		Name        string
+		InstagramID string
+	}
+	if err := query.Order("payments.id").Limit(limit + 1).Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetMessages))
+		return
+	}
+
+	var nextCursor *string
+	if len(rows) > limit {
+		rows = rows[:limit]
+		cursor := strconv.FormatUint(uint64(rows[limit-1].ID), 10)
+		nextCursor = &cursor
+	}
+
+	messages := make([]MessageResponse, len(rows))
+	for i, row := range rows {
+		flags := []string{}
+		if row.MessageFlags != "" {
+			flags = strings.Split(row.MessageFlags, ",")
+		}
+		messages[i] = MessageResponse{
+			PaymentID:     row.ID,
+			Name:          row.Name,
+			InstagramID:   row.InstagramID,
+			Message:       row.Message,
+			MessageStatus: row.MessageStatus,
+			Flags:         flags,
+			PaymentStatus: row.Status,
+			CreatedAt:     row.CreatedAt,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages":    messages,
+		"next_cursor": nextCursor,
+	})
+}
+
+func (h *ModerationHandler) ApproveMessage(c *gin.Context) {
+	h.moderate(c, true)
+}
+
+func (h *ModerationHandler) RejectMessage(c *gin.Context) {
+	h.moderate(c, false)
+}
+
+func (h *ModerationHandler) moderate(c *gin.Context, approve bool) {
+	paymentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidPaymentID))
+		return
+	}
+
+	record, err := h.moderationService.Moderate(uint(paymentID), approve, middleware.CurrentUser(c).ID)
+	switch {
+	case err == nil:
+	case stderrors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrPaymentNotFound))
+		return
+	case stderrors.Is(err, services.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrMessageNotFound))
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToModerateMessage))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payment_id":     record.ID,
+		"message_status": record.MessageStatus,
+	})
+}
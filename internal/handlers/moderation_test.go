@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestListMessagesPages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	user := createTestUser(t, db, "donor")
+
+	var pending []uint
+	for i, status := range []string{
+		constants.MessageStatusPending,
+		constants.MessageStatusApproved,
+		constants.MessageStatusPending,
+		constants.MessageStatusPending,
+		constants.MessageStatusRejected,
+		constants.MessageStatusPending,
+		constants.MessageStatusPending,
+	} {
+		record := models.Payment{
+			UserID:             user.ID,
+			AmountMinor:        int64(1000 + i),
+			ChargedAmountMinor: int64(1000 + i),
+			ChargedCurrency:    constants.CurrencyUSD,
+			Currency:           constants.CurrencyUSD,
+			Status:             constants.PaymentStatusCompleted,
+			Gateway:            constants.PaymentGatewayPayPal,
+			Message:            "hello",
+			MessageStatus:      status,
+		}
+		if err := db.Create(&record).Error; err != nil {
+			t.Fatalf("failed to create payment: %v", err)
+		}
+		if status == constants.MessageStatusPending {
+			pending = append(pending, record.ID)
+		}
+	}
+
+	router := gin.New()
+	router.GET("/messages", NewModerationHandler(db, nil).ListMessages)
+
+	var listed []uint
+	path := "/messages?limit=2"
+	for pages := 0; path != ""; pages++ {
+		if pages > len(pending) {
+			t.Fatalf("paging didn't end")
+		}
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("GET %s status code = %d, want %d", path, recorder.Code, http.StatusOK)
+		}
+
+		var page struct {
+			Messages   []MessageResponse `json:"messages"`
+			NextCursor *string           `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(page.Messages) > 2 {
+			t.Errorf("GET %s listed %d messages, want at most 2", path, len(page.Messages))
+		}
+		for _, message := range page.Messages {
+			listed = append(listed, message.PaymentID)
+		}
+
+		path = ""
+		if page.NextCursor != nil {
+			path = "/messages?limit=2&cursor=" + *page.NextCursor
+		}
+	}
+
+	if len(listed) != len(pending) {
+		t.Fatalf("listed payments %v, want %v", listed, pending)
+	}
+	for i := range pending {
+		if listed[i] != pending[i] {
+			t.Fatalf("listed payments %v, want %v", listed, pending)
+		}
+	}
+
+	for _, path := range []string{"/messages?limit=0", "/messages?limit=101", "/messages?cursor=abc"} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("GET %s status code = %d, want %d", path, recorder.Code, http.StatusBadRequest)
+		}
+	}
+}
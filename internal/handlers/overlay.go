@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/errors"
+	"vinak/pkg/money"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type OverlayHandler struct {
+	db *gorm.DB
+}
+
+func NewOverlayHandler(db *gorm.DB) *OverlayHandler {
+	return &OverlayHandler{
+		db: db,
+	}
+}
+
+type OverlayRequest struct {
+	Name string `json:"name" binding:"required"`
+	// MinAmounts maps a currency to the smallest donation shown, as a decimal
+	MinAmounts map[string]json.Number `json:"min_amounts"`
+	Active     *bool                  `json:"active"`
+}
+
+type OverlayResponse struct {
+	ID         uint                   `json:"id"`
+	Name       string                 `json:"name"`
+	Token      string                 `json:"token"`
+	MinAmounts map[string]json.Number `json:"min_amounts"`
+	Active     bool                   `json:"active"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+func newOverlayResponse(overlay *models.Overlay) OverlayResponse {
+	return OverlayResponse{
+		ID:         overlay.ID,
+		Name:       overlay.Name,
+		Token:      overlay.Token,
+		MinAmounts: decimalAmounts(overlay.MinAmounts),
+		Active:     overlay.Active,
+		CreatedAt:  overlay.CreatedAt,
+	}
+}
+
+func (h *OverlayHandler) ListOverlays(c *gin.Context) {
+	var overlays []models.Overlay
+	if err := h.db.Order("id").Find(&overlays).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetOverlays))
+		return
+	}
+
+	response := make([]OverlayResponse, len(overlays))
+	for i := range overlays {
+		response[i] = newOverlayResponse(&overlays[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{"overlays": response})
+}
+
+func (h *OverlayHandler) CreateOverlay(c *gin.Context) {
+	var req OverlayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	minAmounts, err := parseAmounts(req.MinAmounts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidAmount))
+		return
+	}
+
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSaveOverlay))
+		return
+	}
+
+	overlay := models.Overlay{
+		Name:       req.Name,
+		Token:      base64.RawURLEncoding.EncodeToString(token),
+		MinAmounts: minAmounts,
+		Active:     req.Active == nil || *req.Active,
+	}
+	if err := h.db.Create(&overlay).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSaveOverlay))
+		return
+	}
+
+	c.JSON(http.StatusCreated, newOverlayResponse(&overlay))
+}
+
+func (h *OverlayHandler) UpdateOverlay(c *gin.Context) {
+	overlay, ok := h.findOverlay(c)
+	if !ok {
+		return
+	}
+
+	var req OverlayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	minAmounts, err := parseAmounts(req.MinAmounts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidAmount))
+		return
+	}
+
+	overlay.Name = req.Name
+	overlay.MinAmounts = minAmounts
+	if req.Active != nil {
+		overlay.Active = *req.Active
+	}
+	if err := h.db.Save(overlay).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSaveOverlay))
+		return
+	}
+
+	c.JSON(http.StatusOK, newOverlayResponse(overlay))
+}
+
+func (h *OverlayHandler) DeleteOverlay(c *gin.Context) {
+	overlay, ok := h.findOverlay(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Delete(overlay).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSaveOverlay))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *OverlayHandler) findOverlay(c *gin.Context) (*models.Overlay, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrOverlayNotFound))
+		return nil, false
+	}
+
+	var overlay models.Overlay
+	if err := h.db.First(&overlay, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrOverlayNotFound))
+		return nil, false
+	}
+
+	return &overlay, true
+}
+
+// parseAmounts reads decimal amounts keyed by currency into minor units
+func parseAmounts(values map[string]json.Number) (map[string]int64, error) {
+	minAmounts := make(map[string]int64, len(values))
+	for currency, value := range values {
+		amount, err := money.Parse(value.String(), currency)
+		if err != nil {
+			return nil, err
+		}
+		if amount.Amount < 0 {
+			return nil, money.ErrInvalidAmount
+		}
+		minAmounts[currency] = amount.Amount
+	}
+	return minAmounts, nil
+}
+
+// decimalAmounts formats minor-unit amounts keyed by currency as decimals
+func decimalAmounts(amounts map[string]int64) map[string]json.Number {
+	decimals := make(map[string]json.Number, len(amounts))
+	for currency, amount := range amounts {
+		decimals[currency] = money.New(amount, currency).Decimal()
+	}
+	return decimals
+}
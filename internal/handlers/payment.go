@@ -1,55 +1,88 @@
 package handlers
 
 import (
-	"fmt"
+	"encoding/json"
+	stderrors "errors"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
+	"vinak/internal/middleware"
 	"vinak/internal/models"
+	"vinak/internal/services"
 	"vinak/pkg/constants"
 	"vinak/pkg/errors"
+	"vinak/pkg/money"
 	"vinak/pkg/payment"
-	"vinak/pkg/telegram"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type PaymentHandler struct {
-	db                 *gorm.DB
-	nowpaymentsService *payment.NowPaymentsService
-	paypalService      *payment.PayPalService
-	zarinpalService    *payment.ZarinpalService
-	telegramService    *telegram.TelegramService
+	db                *gorm.DB
+	gateways          *payment.Registry
+	paymentService    *services.PaymentService
+	moderationService *services.ModerationService
+	campaignService   *services.CampaignService
+	promoService      *services.PromoService
+	giftService       *services.GiftService
+	successURL        string
+	failureURL        string
+	// pendingURL is where donors wait while their payment can't be settled yet
+	pendingURL string
+	// refreshAfter is how old the last gateway check of an open payment may be before
+	// GetPayment asks the gateway again
+	refreshAfter time.Duration
 }
 
-func NewPaymentHandler(db *gorm.DB, nowpaymentsService *payment.NowPaymentsService, paypalService *payment.PayPalService, zarinpalService *payment.ZarinpalService, telegramService *telegram.TelegramService) *PaymentHandler {
+func NewPaymentHandler(db *gorm.DB, gateways *payment.Registry, paymentService *services.PaymentService, moderationService *services.ModerationService, campaignService *services.CampaignService, promoService *services.PromoService, giftService *services.GiftService, successURL, failureURL, pendingURL string, refreshAfter time.Duration) *PaymentHandler {
 	return &PaymentHandler{
-		db:                 db,
-		nowpaymentsService: nowpaymentsService,
-		paypalService:      paypalService,
-		zarinpalService:    zarinpalService,
-		telegramService:    telegramService,
+		db:                db,
+		gateways:          gateways,
+		paymentService:    paymentService,
+		moderationService: moderationService,
+		campaignService:   campaignService,
+		promoService:      promoService,
+		giftService:       giftService,
+		successURL:        successURL,
+		failureURL:        failureURL,
+		pendingURL:        pendingURL,
+		refreshAfter:      refreshAfter,
 	}
 }
 
 type CreatePaymentRequest struct {
-	Amount   float64 `json:"amount" binding:"required,gt=0"`
-	Currency string  `json:"currency" binding:"required,oneof=usd irr btc"`
-	Gateway  string  `json:"gateway" binding:"required,oneof=zarinpal paypal nowpayments"`
+	Amount   json.Number `json:"amount" binding:"required"`
+	Currency string      `json:"currency" binding:"required"`
+	Gateway  string      `json:"gateway" binding:"required"`
+	Message  string      `json:"message" binding:"max=280"`
+	// Anonymous hides the donor on public listings for this payment only
+	Anonymous bool `json:"anonymous"`
+	// Campaign is the slug of the campaign the donation goes towards, if any
+	Campaign string `json:"campaign"`
+	// PromoCode can lower the minimum donation or grant bonus tier credit
+	PromoCode string `json:"promo_code" binding:"max=50"`
+	// Recipient makes the donation a gift that counts for them rather than the donor
+	Recipient *GiftRecipientRequest `json:"recipient"`
+}
+
+type GiftRecipientRequest struct {
+	Email       string `json:"email" binding:"omitempty,email,max=255"`
+	InstagramID string `json:"instagram_id" binding:"max=100"`
 }
 
 func (h *PaymentHandler) CreatePayment(c *gin.Context) {
 	apiKey := c.GetHeader(constants.HeaderAPIKey)
 	if apiKey == "" {
-		c.JSON(http.StatusUnauthorized, errors.NewAPIError(http.StatusUnauthorized, "API key is required"))
+		c.JSON(http.StatusUnauthorized, errors.NewAPIError(http.StatusUnauthorized, constants.ErrAPIKeyRequired))
 		return
 	}
 
 	var user models.User
 	if err := h.db.Where("api_key = ?", apiKey).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, errors.NewAPIError(http.StatusUnauthorized, "Invalid API key"))
+		c.JSON(http.StatusUnauthorized, errors.NewAPIError(http.StatusUnauthorized, constants.ErrInvalidAPIKey))
 		return
 	}
 
@@ -59,384 +92,217 @@ func (h *PaymentHandler) CreatePayment(c *gin.Context) {
 		return
 	}
 
-	// Create payment record
-	payment := models.Payment{
-		UserID:   user.ID,
-		Amount:   req.Amount,
-		Status:   constants.PaymentStatusPending,
-		Currency: req.Currency,
+	gateway, err := h.gateways.Get(req.Gateway)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidPaymentGateway))
+		return
 	}
 
-	if err := h.db.Create(&payment).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, "Failed to create payment record"))
+	if err := payment.CheckCurrency(gateway, req.Currency); err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, err.Error()))
 		return
 	}
 
-	fmt.Println("1")
+	amount, err := money.Parse(req.Amount.String(), req.Currency)
+	if err != nil || amount.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidAmount))
+		return
+	}
 
-	switch req.Gateway {
-	case constants.PaymentGatewayZarinpal:
-		if req.Currency != constants.CurrencyIRR {
-			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrZarinpalOnlyIRR))
+	var promo *models.PromoCode
+	if req.PromoCode != "" {
+		promo, err = h.promoService.Find(req.PromoCode)
+		switch {
+		case stderrors.Is(err, services.ErrPromoCodeNotFound):
+			c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrPromoCodeNotFound))
 			return
-		}
-
-		// Convert amount to Tomans for Zarinpal
-		amountInTomans := int(req.Amount)
-
-		// Create Zarinpal payment
-		paymentURL, authority, err := h.zarinpalService.CreatePayment(
-			amountInTomans,
-			"https://ak47album.com/api/payments/zarinpal/callback",
-			"Payment for service",
-			user.Email,
-			"09352439835",
-		)
-		if err != nil {
-			fmt.Println(err)
-			c.JSON(http.StatusInternalServerError, errors.NewPaymentGatewayError(constants.PaymentGatewayZarinpal, err))
+		case stderrors.Is(err, services.ErrPromoCodeNotActive):
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrPromoCodeNotActive))
 			return
-		}
-
-		// Update payment with Zarinpal authority
-		payment.ZarinpalAuthority = &authority
-		if err := h.db.Save(&payment).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, "Failed to update payment record"))
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetPromoCodes))
 			return
 		}
+	}
 
-		// Create payment log
-		paymentLog := models.PaymentLog{
-			PaymentID: payment.ID,
-			Event:     constants.PaymentEventZarinpalCreated,
-			Data:      `{"amount": ` + strconv.Itoa(amountInTomans) + `, "currency": "` + constants.CurrencyIRR + `"}`,
-		}
+	if err := h.promoService.CheckAmount(promo, amount); err != nil {
+		minimum := h.promoService.MinAmount(promo, amount.Currency)
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrAmountBelowMinimum+" of "+minimum.Format()))
+		return
+	}
 
-		if err := h.db.Create(&paymentLog).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, "Failed to create payment log"))
+	var campaignID *uint
+	if req.Campaign != "" {
+		campaign, err := h.campaignService.ForDonation(req.Campaign)
+		switch {
+		case stderrors.Is(err, services.ErrCampaignNotFound):
+			c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrCampaignNotFound))
 			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{
-			"payment_url": paymentURL,
-			"payment_id":  payment.ID,
-			"gateway":     constants.PaymentGatewayZarinpal,
-		})
-
-	case constants.PaymentGatewayPayPal:
-		if req.Currency != constants.CurrencyUSD {
-			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrPayPalOnlyUSD))
+		case stderrors.Is(err, services.ErrCampaignNotActive):
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrCampaignNotActive))
 			return
-		}
-
-		// Create PayPal order
-		order, err := h.paypalService.CreateOrder(
-			req.Amount,
-			req.Currency,
-			"Payment for service",
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, errors.NewPaymentGatewayError(constants.PaymentGatewayPayPal, err))
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetCampaigns))
 			return
 		}
+		campaignID = &campaign.ID
+	}
 
-		// Update payment with PayPal order ID
-		payment.PayPalOrderID = &order.ID
-		if err := h.db.Save(&payment).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, "Failed to update payment record"))
+	creditedUserID := &user.ID
+	var recipient services.GiftRecipient
+	if req.Recipient != nil {
+		recipient = services.NormalizeRecipient(services.GiftRecipient{
+			Email:       req.Recipient.Email,
+			InstagramID: req.Recipient.InstagramID,
+		})
+		if recipient.Email == "" && recipient.InstagramID == "" {
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidRecipient))
 			return
 		}
 
-		// Create payment log
-		paymentLog := models.PaymentLog{
-			PaymentID: payment.ID,
-			Event:     constants.PaymentEventPayPalOrderCreated,
-			Data:      `{"order_id": "` + order.ID + `", "amount": ` + strconv.FormatFloat(req.Amount, 'f', 2, 64) + `, "currency": "` + constants.CurrencyUSD + `"}`,
-		}
-
-		if err := h.db.Create(&paymentLog).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, "Failed to create payment log"))
+		recipientUser, err := h.giftService.Resolve(&user, recipient)
+		switch {
+		case stderrors.Is(err, services.ErrRecipientRequired):
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrRecipientRequired))
 			return
-		}
-
-		// Find the approval URL
-		var approvalURL string
-		for _, link := range order.Links {
-			if link.Rel == "approve" {
-				approvalURL = link.Href
-				break
-			}
-		}
-
-		c.JSON(http.StatusOK, gin.H{
-			"payment_url": approvalURL,
-			"payment_id":  payment.ID,
-			"gateway":     constants.PaymentGatewayPayPal,
-		})
-
-	case constants.PaymentGatewayNowPayments:
-		// Create NowPayments payment
-		nowpaymentsPayment, err := h.nowpaymentsService.CreatePayment(
-			req.Amount,
-			req.Currency,
-			strconv.Itoa(int(payment.ID)),
-			"Payment for service",
-			"http://ak47album.com/api/payments/nowpayments/callback",
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, errors.NewPaymentGatewayError(constants.PaymentGatewayNowPayments, err))
+		case stderrors.Is(err, services.ErrGiftToSelf):
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrGiftToSelf))
 			return
-		}
-
-		// Update payment with NowPayments payment ID
-		payment.NowPaymentsPaymentID = &nowpaymentsPayment.PaymentID
-		if err := h.db.Save(&payment).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, "Failed to update payment record"))
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToResolveRecipient))
 			return
 		}
 
-		// Create payment log
-		paymentLog := models.PaymentLog{
-			PaymentID: payment.ID,
-			Event:     constants.PaymentEventNowPaymentsCreated,
-			Data:      fmt.Sprintf(`{"payment_id": "%s", "amount": %f, "currency": "%s"}`, nowpaymentsPayment.PaymentID, req.Amount, req.Currency),
+		// The gift waits for its recipient to sign up if they have no account yet
+		creditedUserID = nil
+		if recipientUser != nil {
+			creditedUserID = &recipientUser.ID
 		}
+	}
 
-		if err := h.db.Create(&paymentLog).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, "Failed to create payment log"))
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{
-			"payment_id":        nowpaymentsPayment.PaymentID,
-			"payment_status":    nowpaymentsPayment.PaymentStatus,
-			"pay_address":       nowpaymentsPayment.PayAddress,
-			"price_amount":      nowpaymentsPayment.PriceAmount,
-			"price_currency":    nowpaymentsPayment.PriceCurrency,
-			"pay_amount":        nowpaymentsPayment.PayAmount,
-			"pay_currency":      nowpaymentsPayment.PayCurrency,
-			"order_id":          nowpaymentsPayment.OrderID,
-			"order_description": nowpaymentsPayment.OrderDescription,
-			"created_at":        nowpaymentsPayment.CreatedAt,
-			"gateway":           constants.PaymentGatewayNowPayments,
-		})
-
-	default:
-		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidPaymentGateway))
+	// Create payment record
+	record := models.Payment{
+		UserID:               user.ID,
+		AmountMinor:          amount.Amount,
+		ChargedAmountMinor:   amount.Amount,
+		ChargedCurrency:      amount.Currency,
+		Status:               constants.PaymentStatusPending,
+		Currency:             req.Currency,
+		Gateway:              gateway.Name(),
+		Anonymous:            req.Anonymous,
+		CampaignID:           campaignID,
+		RecipientEmail:       recipient.Email,
+		RecipientInstagramID: recipient.InstagramID,
+		CreditedUserID:       creditedUserID,
 	}
-}
 
-func (h *PaymentHandler) HandlePayPalCallback(c *gin.Context) {
-	orderID := c.Query("token")
-	if orderID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Order ID is required"})
-		return
+	if message := strings.TrimSpace(req.Message); message != "" {
+		record.Message = message
+		record.MessageStatus, record.MessageFlags = h.moderationService.Screen(message)
 	}
 
-	// Get payment and user details
-	var payment models.Payment
-	var user models.User
-	if err := h.db.Where("paypal_order_id = ?", orderID).First(&payment).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
-		return
+	if promo != nil {
+		err = h.paymentService.CreateWithPromo(&record, promo)
+	} else {
+		err = h.db.Create(&record).Error
 	}
-	if err := h.db.Where("id = ?", payment.UserID).First(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
+	switch {
+	case stderrors.Is(err, services.ErrPromoCodeNotActive):
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrPromoCodeNotActive))
 		return
-	}
-
-	// Capture PayPal order
-	if err := h.paypalService.CaptureOrder(orderID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to capture PayPal order"})
+	case stderrors.Is(err, services.ErrPromoCodeUsedUp):
+		c.JSON(http.StatusConflict, errors.NewAPIError(http.StatusConflict, constants.ErrPromoCodeUsedUp))
 		return
-	}
-
-	// Update payment status
-	payment.Status = "completed"
-	if err := h.db.Save(&payment).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment status"})
+	case stderrors.Is(err, services.ErrPromoCodeUserLimit):
+		c.JSON(http.StatusConflict, errors.NewAPIError(http.StatusConflict, constants.ErrPromoCodeUserLimit))
 		return
-	}
-
-	// Create payment log
-	paymentLog := models.PaymentLog{
-		PaymentID: payment.ID,
-		Event:     "paypal_payment_captured",
-		Data:      `{"order_id": "` + orderID + `"}`,
-	}
-
-	if err := h.db.Create(&paymentLog).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment log"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToCreatePayment))
 		return
 	}
 
-	// Send Telegram notification
-	if err := h.telegramService.SendPaymentNotification(
-		user.Name,
-		user.InstagramID,
-		payment.Amount,
-		payment.Currency,
-		time.Now(),
-	); err != nil {
-		log.Printf("Failed to send Telegram notification: %v", err)
-	}
-
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
-}
-
-func (h *PaymentHandler) HandleNowPaymentsCallback(c *gin.Context) {
-	var callback struct {
-		PaymentID     string  `json:"payment_id"`
-		PaymentStatus string  `json:"payment_status"`
-		PayAmount     float64 `json:"pay_amount"`
-		PayCurrency   string  `json:"pay_currency"`
-	}
-
-	if err := c.ShouldBindJSON(&callback); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	result, err := gateway.CreatePayment(payment.CreateParams{
+		OrderID:     strconv.FormatUint(uint64(record.ID), 10),
+		Amount:      amount,
+		Description: "Payment for service",
+		Email:       user.Email,
+	})
+	if err != nil {
+		h.failCreation(&record, err)
 	}
-
-	// Get payment and user details
-	var payment models.Payment
-	var user models.User
-	if err := h.db.Where("nowpayments_payment_id = ?", callback.PaymentID).First(&payment).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+	if stderrors.Is(err, money.ErrInvalidAmount) {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, err.Error()))
 		return
 	}
-	if err := h.db.Where("id = ?", payment.UserID).First(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
+	if err != nil {
+		log.Printf("Failed to create %s payment %d: %v", gateway.Name(), record.ID, err)
+		c.JSON(http.StatusInternalServerError, errors.NewPaymentGatewayError(gateway.Name(), err))
 		return
 	}
 
-	// Update payment status
-	payment.Status = "completed"
-	if err := h.db.Save(&payment).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment status"})
-		return
-	}
+	// The gateway order exists now, a retry with the same key must not open another one
+	middleware.KeepIdempotencyKey(c)
 
-	// Create payment log
-	paymentLog := models.PaymentLog{
-		PaymentID: payment.ID,
-		Event:     "nowpayments_payment_completed",
-		Data:      `{"payment_id": "` + callback.PaymentID + `", "status": "` + callback.PaymentStatus + `"}`,
+	// Update payment with the gateway reference callbacks are matched against
+	record.GatewayReference = &result.Reference
+	if result.Charged.Currency != "" {
+		record.ChargedAmountMinor = result.Charged.Amount
+		record.ChargedCurrency = result.Charged.Currency
 	}
-
-	if err := h.db.Create(&paymentLog).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment log"})
+	if err := h.db.Save(&record).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToUpdatePayment))
 		return
 	}
 
-	// Send Telegram notification
-	if err := h.telegramService.SendPaymentNotification(
-		user.Name,
-		user.InstagramID,
-		payment.Amount,
-		payment.Currency,
-		time.Now(),
-	); err != nil {
-		log.Printf("Failed to send Telegram notification: %v", err)
+	if err := h.paymentService.CreateLog(record.ID, constants.PaymentEventCreated, gin.H{
+		"gateway":          gateway.Name(),
+		"reference":        result.Reference,
+		"amount":           amount.Decimal(),
+		"currency":         req.Currency,
+		"charged_amount":   record.ChargedMoney().Decimal(),
+		"charged_currency": record.ChargedCurrency,
+		"message_status":   record.MessageStatus,
+		"campaign_id":      record.CampaignID,
+		"promo_code_id":    record.PromoCodeID,
+		"credited_user_id": record.CreditedUserID,
+		"details":          result.Details,
+	}); err != nil {
+		// The payment can still go ahead, failing here would only strand the gateway order
+		log.Printf("Failed to log creation of payment %d: %v", record.ID, err)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
-}
-
-func (h *PaymentHandler) GetTopUsers(c *gin.Context) {
-	var usdTopUsers []struct {
-		Name        string  `json:"name"`
-		InstagramID string  `json:"instagram_id"`
-		TotalAmount float64 `json:"total_amount"`
-		Currency    string  `json:"currency"`
+	response := gin.H{}
+	for key, value := range result.Details {
+		response[key] = value
 	}
-
-	err := h.db.Model(&models.Payment{}).
-		Select("users.name, users.instagram_id, SUM(payments.amount) as total_amount").
-		Joins("JOIN users ON users.id = payments.user_id").
-		Where("payments.status = ?", constants.PaymentStatusCompleted).
-		Where("payments.currency = ?", constants.CurrencyUSD).
-		Group("users.name, users.instagram_id").
-		Order("total_amount DESC").
-		Limit(10).
-		Scan(&usdTopUsers).Error
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetTopUsers))
-		return
+	response["payment_id"] = record.ID
+	response["payment_url"] = result.PaymentURL
+	response["gateway"] = gateway.Name()
+	response["gateway_reference"] = result.Reference
+	response["charged_amount"] = record.ChargedMoney().Decimal()
+	response["charged_currency"] = record.ChargedCurrency
+	if record.Message != "" {
+		response["message_status"] = record.MessageStatus
 	}
-
-	// Add currency to USD supporters
-	for i := range usdTopUsers {
-		usdTopUsers[i].Currency = constants.CurrencyUSD
+	if record.IsGift() {
+		response["gift"] = true
+		response["gift_claimed"] = record.CreditedUserID != nil
 	}
-
-	// Get top IRR supporters
-	var irrTopUsers []struct {
-		Name        string  `json:"name"`
-		InstagramID string  `json:"instagram_id"`
-		TotalAmount float64 `json:"total_amount"`
-		Currency    string  `json:"currency"`
+	if promo != nil {
+		response["promo_code"] = promo.Code
+		response["tier_bonus"] = money.New(record.TierBonusMinor, record.Currency).Decimal()
 	}
 
-	err = h.db.Model(&models.Payment{}).
-		Select("users.name, users.instagram_id, SUM(payments.amount) as total_amount").
-		Joins("JOIN users ON users.id = payments.user_id").
-		Where("payments.status = ?", constants.PaymentStatusCompleted).
-		Where("payments.currency = ?", constants.CurrencyIRR).
-		Group("users.name, users.instagram_id").
-		Order("total_amount DESC").
-		Limit(10).
-		Scan(&irrTopUsers).Error
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetTopUsers))
-		return
-	}
-
-	// Add currency to IRR supporters
-	for i := range irrTopUsers {
-		irrTopUsers[i].Currency = constants.CurrencyIRR
-	}
-
-	// Format response
-	supporters := make([]struct {
-		Name      string  `json:"name"`
-		Instagram string  `json:"instagram"`
-		Amount    float64 `json:"amount"`
-		Currency  string  `json:"currency"`
-	}, len(usdTopUsers)+len(irrTopUsers))
-
-	// Add USD supporters
-	for i, user := range usdTopUsers {
-		supporters[i] = struct {
-			Name      string  `json:"name"`
-			Instagram string  `json:"instagram"`
-			Amount    float64 `json:"amount"`
-			Currency  string  `json:"currency"`
-		}{
-			Name:      user.Name,
-			Instagram: user.InstagramID,
-			Amount:    user.TotalAmount,
-			Currency:  user.Currency,
-		}
-	}
+	c.JSON(http.StatusOK, response)
+}
 
-	// Add IRR supporters
-	for i, user := range irrTopUsers {
-		supporters[len(usdTopUsers)+i] = struct {
-			Name      string  `json:"name"`
-			Instagram string  `json:"instagram"`
-			Amount    float64 `json:"amount"`
-			Currency  string  `json:"currency"`
-		}{
-			Name:      user.Name,
-			Instagram: user.InstagramID,
-			Amount:    user.TotalAmount,
-			Currency:  user.Currency,
-		}
+// failCreation fails a payment the gateway wouldn't create, so it doesn't wait for the
+// expirer while holding a promo code use
+func (h *PaymentHandler) failCreation(record *models.Payment, cause error) {
+	if _, _, err := h.paymentService.Transition(record.ID, constants.PaymentStatusFailed, "", map[string]interface{}{
+		"source": constants.PaymentSourceCreation,
+		"error":  cause.Error(),
+	}); err != nil {
+		log.Printf("Failed to mark payment %d as failed: %v", record.ID, err)
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"supporters": supporters,
-	})
 }
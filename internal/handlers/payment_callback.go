@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	stderrors "errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/errors"
+	"vinak/pkg/payment"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleNotification returns the server-to-server callback handler for a gateway that
+// implements payment.NotificationParser (NowPayments IPN, PayPal webhooks).
+func (h *PaymentHandler) HandleNotification(gatewayName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+
+		gateway, err := h.gateways.Get(gatewayName)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": constants.ErrInvalidPaymentGateway})
+			return
+		}
+
+		parser, ok := gateway.(payment.NotificationParser)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": constants.ErrInvalidPaymentGateway})
+			return
+		}
+
+		notification, err := parser.ParseNotification(c.Request.Header, body)
+		switch {
+		case stderrors.Is(err, payment.ErrInvalidSignature):
+			log.Printf("Rejected %s notification with invalid signature", gatewayName)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": constants.ErrInvalidSignature})
+			return
+		case stderrors.Is(err, payment.ErrUnsupportedNotification):
+			// Acknowledge so the gateway doesn't keep retrying events we don't handle
+			c.JSON(http.StatusOK, gin.H{"status": "ignored"})
+			return
+		case err != nil:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		record, err := h.findNotificationPayment(gatewayName, notification)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrPaymentNotFound})
+			return
+		}
+
+		// Expired payments are still verified, a late approval completes them
+		if notification.Verify && models.CanTransitionPayment(record.Status, constants.PaymentStatusCompleted) {
+			result, err := gateway.VerifyPayment(payment.VerifyParams{
+				Reference: *record.GatewayReference,
+				Amount:    record.ChargedMoney(),
+			})
+			if err != nil {
+				// Fail the delivery so the gateway retries it later
+				log.Printf("Failed to verify %s payment %d: %v", gatewayName, record.ID, err)
+				c.JSON(http.StatusBadGateway, errors.NewPaymentGatewayError(gatewayName, err))
+				return
+			}
+			notification.Status = result.Status
+			notification.TransactionID = result.TransactionID
+		}
+
+		if notification.Refund != nil {
+			_, _, err = h.paymentService.RecordGatewayRefund(record.ID, *notification.Refund, notification.Data)
+		} else {
+			_, _, err = h.paymentService.Transition(record.ID, notification.Status, notification.TransactionID, notification.Data)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": constants.ErrFailedToUpdatePayment})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success"})
+	}
+}
+
+// HandlePayPalReturn is where PayPal sends the donor's browser after approving an order.
+func (h *PaymentHandler) HandlePayPalReturn(c *gin.Context) {
+	orderID := c.Query("token")
+	if orderID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order ID is required"})
+		return
+	}
+
+	record, err := h.findPaymentByReference(constants.PaymentGatewayPayPal, orderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrPaymentNotFound})
+		return
+	}
+
+	// Orders approved after the payment expired are still captured and complete it
+	if !models.CanTransitionPayment(record.Status, constants.PaymentStatusCompleted) {
+		h.redirectForStatus(c, record)
+		return
+	}
+
+	gateway, err := h.gateways.Get(constants.PaymentGatewayPayPal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": constants.ErrInvalidPaymentGateway})
+		return
+	}
+
+	// Capture PayPal order
+	status, transactionID := record.Status, ""
+	result, err := gateway.VerifyPayment(payment.VerifyParams{
+		Reference: orderID,
+		Amount:    record.ChargedMoney(),
+	})
+	if err == nil {
+		status, transactionID = result.Status, result.TransactionID
+	} else {
+		// The webhook may have captured the order first, so ask PayPal where it stands
+		log.Printf("Failed to capture PayPal order %s: %v", orderID, err)
+		if current, err := gateway.GetPaymentStatus(orderID); err == nil {
+			status = current.Status
+		}
+	}
+
+	record, _, err = h.paymentService.Transition(record.ID, status, transactionID, gin.H{"order_id": orderID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": constants.ErrFailedToUpdatePayment})
+		return
+	}
+
+	h.redirectForStatus(c, record)
+}
+
+func (h *PaymentHandler) HandleZarinpalCallback(c *gin.Context) {
+	authority := c.Query("Authority")
+	status := c.Query("Status")
+	if authority == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Authority is required"})
+		return
+	}
+
+	record, err := h.findPaymentByReference(constants.PaymentGatewayZarinpal, authority)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrPaymentNotFound})
+		return
+	}
+
+	// The donor may refresh the return page, so settled payments only redirect again.
+	// Expired ones are still verified in case the donor paid just past the window.
+	if !models.CanTransitionPayment(record.Status, constants.PaymentStatusCompleted) {
+		h.redirectForStatus(c, record)
+		return
+	}
+
+	if status != constants.ZarinpalStatusOK {
+		record, _, err = h.paymentService.Transition(record.ID, constants.PaymentStatusFailed, "", gin.H{
+			"authority": authority,
+			"reason":    "payment was cancelled or rejected by the bank",
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": constants.ErrFailedToUpdatePayment})
+			return
+		}
+		h.redirectForStatus(c, record)
+		return
+	}
+
+	gateway, err := h.gateways.Get(constants.PaymentGatewayZarinpal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": constants.ErrInvalidPaymentGateway})
+		return
+	}
+
+	result, err := gateway.VerifyPayment(payment.VerifyParams{
+		Reference: authority,
+		Amount:    record.ChargedMoney(),
+	})
+	if err != nil {
+		// Zarinpal didn't answer, so the donor may well have paid. An open payment stays
+		// open for the reconciler to verify once Zarinpal is reachable again.
+		log.Printf("Failed to verify Zarinpal payment %d: %v", record.ID, err)
+		h.redirectForStatus(c, record)
+		return
+	}
+
+	record, _, err = h.paymentService.Transition(record.ID, result.Status, result.TransactionID, gin.H{"authority": authority})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": constants.ErrFailedToUpdatePayment})
+		return
+	}
+
+	h.redirectForStatus(c, record)
+}
+
+func (h *PaymentHandler) findNotificationPayment(gateway string, notification *payment.Notification) (*models.Payment, error) {
+	if notification.Reference == "" {
+		return h.findPayment("gateway = ? AND gateway_transaction_id = ?", gateway, notification.TransactionID)
+	}
+	return h.findPaymentByReference(gateway, notification.Reference)
+}
+
+func (h *PaymentHandler) findPaymentByReference(gateway, reference string) (*models.Payment, error) {
+	return h.findPayment("gateway = ? AND gateway_reference = ?", gateway, reference)
+}
+
+func (h *PaymentHandler) findPayment(query string, args ...interface{}) (*models.Payment, error) {
+	var record models.Payment
+	if err := h.db.Where(query, args...).First(&record).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (h *PaymentHandler) redirectForStatus(c *gin.Context, record *models.Payment) {
+	switch record.Status {
+	case constants.PaymentStatusCompleted, constants.PaymentStatusAwaitingConfirmation:
+		h.redirectToResult(c, h.successURL, record.ID)
+	case constants.PaymentStatusPending:
+		h.redirectToResult(c, h.pendingURL, record.ID)
+	default:
+		h.redirectToResult(c, h.failureURL, record.ID)
+	}
+}
+
+func (h *PaymentHandler) redirectToResult(c *gin.Context, target string, paymentID uint) {
+	redirectURL, err := url.Parse(target)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, "Invalid redirect URL"))
+		return
+	}
+
+	query := redirectURL.Query()
+	query.Set("payment_id", strconv.FormatUint(uint64(paymentID), 10))
+	redirectURL.RawQuery = query.Encode()
+
+	c.Redirect(http.StatusFound, redirectURL.String())
+}
@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"vinak/internal/models"
+	"vinak/internal/services"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
+	"vinak/pkg/payment"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// fakeGateway answers verifications, status checks and notifications with fixed
+// results and counts the verifications
+type fakeGateway struct {
+	name         string
+	verify       *payment.VerifyResult
+	verifyErr    error
+	verified     int
+	status       *payment.StatusResult
+	notification *payment.Notification
+}
+
+func (g *fakeGateway) Name() string {
+	return g.name
+}
+
+func (g *fakeGateway) SupportedCurrencies() []string {
+	return []string{constants.CurrencyIRR, constants.CurrencyUSD}
+}
+
+func (g *fakeGateway) CreatePayment(params payment.CreateParams) (*payment.CreateResult, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (g *fakeGateway) VerifyPayment(params payment.VerifyParams) (*payment.VerifyResult, error) {
+	g.verified++
+	return g.verify, g.verifyErr
+}
+
+func (g *fakeGateway) GetPaymentStatus(reference string) (*payment.StatusResult, error) {
+	if g.status == nil {
+		return nil, errors.New("not implemented")
+	}
+	return g.status, nil
+}
+
+func (g *fakeGateway) ParseNotification(header http.Header, body []byte) (*payment.Notification, error) {
+	if g.notification == nil {
+		return nil, payment.ErrUnsupportedNotification
+	}
+	return g.notification, nil
+}
+
+func newCallbackTest(t *testing.T, gateway payment.Gateway) (*gin.Engine, *gorm.DB, *models.Payment) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	user := createTestUser(t, db, "donor")
+
+	reference := "REF-1"
+	record := models.Payment{
+		UserID:             user.ID,
+		CreditedUserID:     &user.ID,
+		AmountMinor:        100000,
+		ChargedAmountMinor: 100000,
+		ChargedCurrency:    constants.CurrencyIRR,
+		Currency:           constants.CurrencyIRR,
+		Status:             constants.PaymentStatusPending,
+		Gateway:            gateway.Name(),
+		GatewayReference:   &reference,
+	}
+	if err := db.Create(&record).Error; err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+
+	gateways := payment.NewRegistry(gateway)
+	handler := NewPaymentHandler(db, gateways, services.NewPaymentService(db, gateways), nil, nil, nil, nil,
+		"https://example.com/success", "https://example.com/failure", "https://example.com/pending", 0)
+
+	router := gin.New()
+	router.GET("/zarinpal/callback", handler.HandleZarinpalCallback)
+	router.GET("/paypal/return", handler.HandlePayPalReturn)
+	router.POST("/notify", handler.HandleNotification(gateway.Name()))
+	return router, db, &record
+}
+
+func reloadPayment(t *testing.T, db *gorm.DB, id uint) *models.Payment {
+	t.Helper()
+
+	var record models.Payment
+	if err := db.First(&record, id).Error; err != nil {
+		t.Fatalf("failed to reload payment: %v", err)
+	}
+	return &record
+}
+
+func TestHandleZarinpalCallback(t *testing.T) {
+	tests := []struct {
+		name       string
+		current    string
+		status     string
+		verify     *payment.VerifyResult
+		verifyErr  error
+		want       string
+		wantPage   string
+		wantVerify int
+	}{
+		{
+			name: "verified", current: constants.PaymentStatusPending, status: constants.ZarinpalStatusOK,
+			verify: &payment.VerifyResult{Status: constants.PaymentStatusCompleted, TransactionID: "42"},
+			want:   constants.PaymentStatusCompleted, wantPage: "success", wantVerify: 1,
+		},
+		{
+			name: "cancelled by the donor", current: constants.PaymentStatusPending, status: "NOK",
+			want: constants.PaymentStatusFailed, wantPage: "failure",
+		},
+		{
+			name: "rejected by zarinpal", current: constants.PaymentStatusPending, status: constants.ZarinpalStatusOK,
+			verify: &payment.VerifyResult{Status: constants.PaymentStatusFailed},
+			want:   constants.PaymentStatusFailed, wantPage: "failure", wantVerify: 1,
+		},
+		{
+			name: "zarinpal unreachable", current: constants.PaymentStatusPending, status: constants.ZarinpalStatusOK,
+			verifyErr: errors.New("i/o timeout"),
+			want:      constants.PaymentStatusPending, wantPage: "pending", wantVerify: 1,
+		},
+		{
+			name: "refreshed after completion", current: constants.PaymentStatusCompleted, status: constants.ZarinpalStatusOK,
+			want: constants.PaymentStatusCompleted, wantPage: "success",
+		},
+		{
+			name: "verified after expiry", current: constants.PaymentStatusExpired, status: constants.ZarinpalStatusOK,
+			verify: &payment.VerifyResult{Status: constants.PaymentStatusCompleted, TransactionID: "42"},
+			want:   constants.PaymentStatusCompleted, wantPage: "success", wantVerify: 1,
+		},
+		{
+			name: "rejected after expiry", current: constants.PaymentStatusExpired, status: constants.ZarinpalStatusOK,
+			verify: &payment.VerifyResult{Status: constants.PaymentStatusFailed},
+			want:   constants.PaymentStatusExpired, wantPage: "failure", wantVerify: 1,
+		},
+		{
+			name: "zarinpal unreachable after expiry", current: constants.PaymentStatusExpired, status: constants.ZarinpalStatusOK,
+			verifyErr: errors.New("i/o timeout"),
+			want:      constants.PaymentStatusExpired, wantPage: "failure", wantVerify: 1,
+		},
+		{
+			name: "cancelled after expiry", current: constants.PaymentStatusExpired, status: "NOK",
+			want: constants.PaymentStatusExpired, wantPage: "failure",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := &fakeGateway{name: constants.PaymentGatewayZarinpal, verify: tt.verify, verifyErr: tt.verifyErr}
+			router, db, record := newCallbackTest(t, gateway)
+			if err := db.Model(record).Update("status", tt.current).Error; err != nil {
+				t.Fatalf("failed to set payment status: %v", err)
+			}
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/zarinpal/callback?Authority=REF-1&Status="+tt.status, nil))
+
+			if recorder.Code != http.StatusFound {
+				t.Fatalf("status code = %d, want %d", recorder.Code, http.StatusFound)
+			}
+			if location := recorder.Header().Get("Location"); !strings.Contains(location, "/"+tt.wantPage+"?") {
+				t.Errorf("redirected to %s, want the %s page", location, tt.wantPage)
+			}
+			if gateway.verified != tt.wantVerify {
+				t.Errorf("verified %d times, want %d", gateway.verified, tt.wantVerify)
+			}
+			if got := reloadPayment(t, db, record.ID).Status; got != tt.want {
+				t.Errorf("payment status = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlePayPalReturn(t *testing.T) {
+	tests := []struct {
+		name       string
+		current    string
+		verify     *payment.VerifyResult
+		verifyErr  error
+		status     *payment.StatusResult
+		want       string
+		wantPage   string
+		wantVerify int
+	}{
+		{
+			name: "captured", current: constants.PaymentStatusPending,
+			verify: &payment.VerifyResult{Status: constants.PaymentStatusCompleted, TransactionID: "CAPTURE-1"},
+			want:   constants.PaymentStatusCompleted, wantPage: "success", wantVerify: 1,
+		},
+		{
+			name: "captured after expiry", current: constants.PaymentStatusExpired,
+			verify: &payment.VerifyResult{Status: constants.PaymentStatusCompleted, TransactionID: "CAPTURE-1"},
+			want:   constants.PaymentStatusCompleted, wantPage: "success", wantVerify: 1,
+		},
+		{
+			name: "captured by the webhook first", current: constants.PaymentStatusPending,
+			verifyErr: errors.New("ORDER_ALREADY_CAPTURED"),
+			status:    &payment.StatusResult{Status: constants.PaymentStatusCompleted},
+			want:      constants.PaymentStatusCompleted, wantPage: "success", wantVerify: 1,
+		},
+		{
+			name: "paypal unreachable", current: constants.PaymentStatusPending,
+			verifyErr: errors.New("i/o timeout"),
+			want:      constants.PaymentStatusPending, wantPage: "pending", wantVerify: 1,
+		},
+		{
+			name: "refreshed after a refund", current: constants.PaymentStatusRefunded,
+			want: constants.PaymentStatusRefunded, wantPage: "failure",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := &fakeGateway{name: constants.PaymentGatewayPayPal, verify: tt.verify, verifyErr: tt.verifyErr, status: tt.status}
+			router, db, record := newCallbackTest(t, gateway)
+			if err := db.Model(record).Update("status", tt.current).Error; err != nil {
+				t.Fatalf("failed to set payment status: %v", err)
+			}
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/paypal/return?token=REF-1", nil))
+
+			if recorder.Code != http.StatusFound {
+				t.Fatalf("status code = %d, want %d", recorder.Code, http.StatusFound)
+			}
+			if location := recorder.Header().Get("Location"); !strings.Contains(location, "/"+tt.wantPage+"?") {
+				t.Errorf("redirected to %s, want the %s page", location, tt.wantPage)
+			}
+			if gateway.verified != tt.wantVerify {
+				t.Errorf("verified %d times, want %d", gateway.verified, tt.wantVerify)
+			}
+			if got := reloadPayment(t, db, record.ID).Status; got != tt.want {
+				t.Errorf("payment status = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleNotification(t *testing.T) {
+	tests := []struct {
+		name         string
+		current      string
+		notification *payment.Notification
+		verify       *payment.VerifyResult
+		verifyErr    error
+		wantCode     int
+		want         string
+		wantVerify   int
+	}{
+		{
+			name: "confirmed", current: constants.PaymentStatusPending,
+			notification: &payment.Notification{Reference: "REF-1", Status: constants.PaymentStatusCompleted},
+			wantCode:     http.StatusOK, want: constants.PaymentStatusCompleted,
+		},
+		{
+			name: "partly paid", current: constants.PaymentStatusPending,
+			notification: &payment.Notification{Reference: "REF-1", Status: constants.PaymentStatusAwaitingConfirmation},
+			wantCode:     http.StatusOK, want: constants.PaymentStatusAwaitingConfirmation,
+		},
+		{
+			name: "late transfer after expiry", current: constants.PaymentStatusExpired,
+			notification: &payment.Notification{Reference: "REF-1", Status: constants.PaymentStatusCompleted},
+			wantCode:     http.StatusOK, want: constants.PaymentStatusCompleted,
+		},
+		{
+			name: "approved and captured", current: constants.PaymentStatusPending,
+			notification: &payment.Notification{Reference: "REF-1", Status: constants.PaymentStatusPending, Verify: true},
+			verify:       &payment.VerifyResult{Status: constants.PaymentStatusCompleted, TransactionID: "CAPTURE-1"},
+			wantCode:     http.StatusOK, want: constants.PaymentStatusCompleted, wantVerify: 1,
+		},
+		{
+			name: "approved after expiry", current: constants.PaymentStatusExpired,
+			notification: &payment.Notification{Reference: "REF-1", Status: constants.PaymentStatusPending, Verify: true},
+			verify:       &payment.VerifyResult{Status: constants.PaymentStatusCompleted, TransactionID: "CAPTURE-1"},
+			wantCode:     http.StatusOK, want: constants.PaymentStatusCompleted, wantVerify: 1,
+		},
+		{
+			name: "capture fails and is retried", current: constants.PaymentStatusPending,
+			notification: &payment.Notification{Reference: "REF-1", Status: constants.PaymentStatusPending, Verify: true},
+			verifyErr:    errors.New("i/o timeout"),
+			wantCode:     http.StatusBadGateway, want: constants.PaymentStatusPending, wantVerify: 1,
+		},
+		{
+			name: "failure after completion is ignored", current: constants.PaymentStatusCompleted,
+			notification: &payment.Notification{Reference: "REF-1", Status: constants.PaymentStatusFailed},
+			wantCode:     http.StatusOK, want: constants.PaymentStatusCompleted,
+		},
+		{
+			name: "unsupported event", current: constants.PaymentStatusPending,
+			wantCode: http.StatusOK, want: constants.PaymentStatusPending,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := &fakeGateway{
+				name:         constants.PaymentGatewayPayPal,
+				verify:       tt.verify,
+				verifyErr:    tt.verifyErr,
+				notification: tt.notification,
+			}
+			router, db, record := newCallbackTest(t, gateway)
+			if err := db.Model(record).Update("status", tt.current).Error; err != nil {
+				t.Fatalf("failed to set payment status: %v", err)
+			}
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader("{}")))
+
+			if recorder.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", recorder.Code, tt.wantCode)
+			}
+			if gateway.verified != tt.wantVerify {
+				t.Errorf("verified %d times, want %d", gateway.verified, tt.wantVerify)
+			}
+			if got := reloadPayment(t, db, record.ID).Status; got != tt.want {
+				t.Errorf("payment status = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleNotificationRefund(t *testing.T) {
+	transactionID := "CAPTURE-1"
+	gateway := &fakeGateway{name: constants.PaymentGatewayPayPal}
+	router, db, record := newCallbackTest(t, gateway)
+	if err := db.Model(record).Updates(map[string]interface{}{
+		"status":                 constants.PaymentStatusCompleted,
+		"gateway_transaction_id": transactionID,
+	}).Error; err != nil {
+		t.Fatalf("failed to complete payment: %v", err)
+	}
+
+	// Refund events only carry the capture, not the order
+	notify := func(reference string, amount int64) {
+		t.Helper()
+		gateway.notification = &payment.Notification{
+			TransactionID: transactionID,
+			Refund:        &payment.RefundNotice{Reference: reference, Amount: money.New(amount, constants.CurrencyIRR)},
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader("{}")))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("refund %s status code = %d, want %d", reference, recorder.Code, http.StatusOK)
+		}
+	}
+
+	notify("REFUND-1", 40000)
+	if got := reloadPayment(t, db, record.ID); got.Status != constants.PaymentStatusPartiallyRefunded || got.RefundedAmountMinor != 40000 {
+		t.Errorf("payment is %s with %d refunded, want partially_refunded with 40000", got.Status, got.RefundedAmountMinor)
+	}
+
+	// Redelivered, then the rest refunded
+	notify("REFUND-1", 40000)
+	notify("REFUND-2", 60000)
+	if got := reloadPayment(t, db, record.ID); got.Status != constants.PaymentStatusRefunded || got.RefundedAmountMinor != 100000 {
+		t.Errorf("payment is %s with %d refunded, want refunded with 100000", got.Status, got.RefundedAmountMinor)
+	}
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"vinak/internal/middleware"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/errors"
+	"vinak/pkg/money"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPaymentsPageSize = 20
+	maxPaymentsPageSize     = 100
+	latestPaymentLogs       = 10
+)
+
+type PaymentResponse struct {
+	ID                   uint        `json:"id"`
+	Status               string      `json:"status"`
+	Gateway              string      `json:"gateway"`
+	Amount               json.Number `json:"amount"`
+	Currency             string      `json:"currency"`
+	ChargedAmount        json.Number `json:"charged_amount"`
+	ChargedCurrency      string      `json:"charged_currency"`
+	RefundedAmount       json.Number `json:"refunded_amount"`
+	GatewayReference     *string     `json:"gateway_reference"`
+	GatewayTransactionID *string     `json:"gateway_transaction_id"`
+	Message              string      `json:"message,omitempty"`
+	MessageStatus        string      `json:"message_status,omitempty"`
+	Anonymous            bool        `json:"anonymous"`
+	CampaignID           *uint       `json:"campaign_id,omitempty"`
+	PromoCodeID          *uint       `json:"promo_code_id,omitempty"`
+	RecipientEmail       string      `json:"recipient_email,omitempty"`
+	RecipientInstagramID string      `json:"recipient_instagram_id,omitempty"`
+	CreatedAt            time.Time   `json:"created_at"`
+	UpdatedAt            time.Time   `json:"updated_at"`
+}
+
+func newPaymentResponse(record *models.Payment) PaymentResponse {
+	return PaymentResponse{
+		ID:                   record.ID,
+		Status:               record.Status,
+		Gateway:              record.Gateway,
+		Amount:               record.Money().Decimal(),
+		Currency:             record.Currency,
+		ChargedAmount:        record.ChargedMoney().Decimal(),
+		ChargedCurrency:      record.ChargedCurrency,
+		RefundedAmount:       record.RefundedMoney().Decimal(),
+		GatewayReference:     record.GatewayReference,
+		GatewayTransactionID: record.GatewayTransactionID,
+		Message:              record.Message,
+		MessageStatus:        record.MessageStatus,
+		Anonymous:            record.Anonymous,
+		CampaignID:           record.CampaignID,
+		PromoCodeID:          record.PromoCodeID,
+		RecipientEmail:       record.RecipientEmail,
+		RecipientInstagramID: record.RecipientInstagramID,
+		CreatedAt:            record.CreatedAt,
+		UpdatedAt:            record.UpdatedAt,
+	}
+}
+
+// ListMyPayments returns the caller's payments newest first. The cursor is the id of the
+// last payment on the previous page, returned as next_cursor.
+func (h *PaymentHandler) ListMyPayments(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+
+	limit := defaultPaymentsPageSize
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxPaymentsPageSize {
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	query := h.db.Where("user_id = ?", user.ID)
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		id, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidCursor))
+			return
+		}
+		query = query.Where("id < ?", id)
+	}
+
+	if status := c.Query("status"); status != "" {
+		if !models.IsPaymentStatus(status) {
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidStatus))
+			return
+		}
+		query = query.Where("status = ?", status)
+	}
+
+	if currency := c.Query("currency"); currency != "" {
+		if _, err := money.Exponent(currency); err != nil {
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidCurrency))
+			return
+		}
+		query = query.Where("currency = ?", currency)
+	}
+
+	// One extra row tells whether there is another page
+	var records []models.Payment
+	if err := query.Order("id DESC").Limit(limit + 1).Find(&records).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetPayments))
+		return
+	}
+
+	var nextCursor *string
+	if len(records) > limit {
+		records = records[:limit]
+		cursor := strconv.FormatUint(uint64(records[limit-1].ID), 10)
+		nextCursor = &cursor
+	}
+
+	payments := make([]PaymentResponse, len(records))
+	for i := range records {
+		payments[i] = newPaymentResponse(&records[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payments":    payments,
+		"next_cursor": nextCursor,
+	})
+}
+
+type PaymentLogResponse struct {
+	Event     string                 `json:"event"`
+	Data      map[string]interface{} `json:"data"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// publicLogFields lists the payment log events donors are shown and the fields of each
+// they may see. The rest of the log, such as gateway notifications, refund reasons,
+// moderation details and admin ids, is for admins only.
+var publicLogFields = map[string][]string{
+	constants.PaymentEventCreated:           {"amount", "currency", "charged_amount", "charged_currency"},
+	constants.PaymentEventStatusChanged:     {"from", "to"},
+	constants.PaymentEventRefundRequested:   {"amount", "currency"},
+	constants.PaymentEventMessageModerated:  {"to"},
+	constants.PaymentEventPromoCodeRedeemed: {"code", "tier_bonus"},
+	constants.PaymentEventGiftClaimed:       {},
+}
+
+// newPaymentLogResponse keeps the public fields of a log entry
+func newPaymentLogResponse(entry *models.PaymentLog) PaymentLogResponse {
+	var data map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(entry.Data))
+	// Amounts stay exact decimals
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		log.Printf("Failed to decode payment log %d: %v", entry.ID, err)
+	}
+
+	public := make(map[string]interface{})
+	for _, field := range publicLogFields[entry.Event] {
+		if value, ok := data[field]; ok {
+			public[field] = value
+		}
+	}
+
+	return PaymentLogResponse{
+		Event:     entry.Event,
+		Data:      public,
+		CreatedAt: entry.CreatedAt,
+	}
+}
+
+// GetPayment returns one of the caller's payments for the frontend to poll. Open payments
+// whose last gateway check is older than refreshAfter are synced with the gateway first.
+func (h *PaymentHandler) GetPayment(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+
+	paymentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidPaymentID))
+		return
+	}
+
+	var record models.Payment
+	if err := h.db.Where("id = ? AND user_id = ?", paymentID, user.ID).First(&record).Error; err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrPaymentNotFound))
+		return
+	}
+
+	var gatewayStatus string
+	var gatewayDetails map[string]interface{}
+	if models.IsOpenPaymentStatus(record.Status) && record.GatewayReference != nil &&
+		(record.LastCheckedAt == nil || time.Since(*record.LastCheckedAt) > h.refreshAfter) {
+		synced, status, err := h.paymentService.Sync(&record)
+		if err != nil {
+			// Serve the stored state, the reconciler catches up later
+			log.Printf("Failed to refresh payment %d: %v", record.ID, err)
+		} else {
+			record = *synced
+			if status != nil {
+				gatewayStatus = status.GatewayStatus
+				gatewayDetails = status.Details
+			}
+		}
+	}
+
+	// Without a fresh answer fall back to what the gateway returned on creation, which
+	// holds e.g. the NowPayments deposit address
+	if gatewayDetails == nil && models.IsOpenPaymentStatus(record.Status) {
+		gatewayDetails = h.createdDetails(record.ID)
+	}
+
+	publicEvents := make([]string, 0, len(publicLogFields))
+	for event := range publicLogFields {
+		publicEvents = append(publicEvents, event)
+	}
+
+	var logs []models.PaymentLog
+	if err := h.db.Where("payment_id = ? AND event IN ?", record.ID, publicEvents).Order("id DESC").Limit(latestPaymentLogs).Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetPayments))
+		return
+	}
+
+	events := make([]PaymentLogResponse, len(logs))
+	for i := range logs {
+		events[i] = newPaymentLogResponse(&logs[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payment":         newPaymentResponse(&record),
+		"events":          events,
+		"gateway_status":  gatewayStatus,
+		"gateway_details": gatewayDetails,
+		"last_checked_at": record.LastCheckedAt,
+	})
+}
+
+func (h *PaymentHandler) createdDetails(paymentID uint) map[string]interface{} {
+	var created models.PaymentLog
+	if err := h.db.Where("payment_id = ? AND event = ?", paymentID, constants.PaymentEventCreated).First(&created).Error; err != nil {
+		return nil
+	}
+
+	var data struct {
+		Details map[string]interface{} `json:"details"`
+	}
+	if err := json.Unmarshal([]byte(created.Data), &data); err != nil {
+		return nil
+	}
+	return data.Details
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+	"vinak/internal/models"
+	"vinak/internal/services"
+	"vinak/pkg/constants"
+	"vinak/pkg/errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var promoCodePattern = regexp.MustCompile(`^[A-Z0-9_-]+$`)
+
+type PromoCodeHandler struct {
+	db           *gorm.DB
+	promoService *services.PromoService
+}
+
+func NewPromoCodeHandler(db *gorm.DB, promoService *services.PromoService) *PromoCodeHandler {
+	return &PromoCodeHandler{
+		db:           db,
+		promoService: promoService,
+	}
+}
+
+type PromoCodeRequest struct {
+	Code        string `json:"code" binding:"required,max=50"`
+	Description string `json:"description" binding:"max=255"`
+	// MinAmounts maps a currency to the smallest donation the code allows, as a decimal.
+	// Currencies left out keep the usual minimum.
+	MinAmounts     map[string]json.Number `json:"min_amounts"`
+	BonusPercent   int                    `json:"bonus_percent" binding:"min=0,max=1000"`
+	MaxUses        int                    `json:"max_uses" binding:"min=0"`
+	MaxUsesPerUser int                    `json:"max_uses_per_user" binding:"min=0"`
+	StartsAt       *time.Time             `json:"starts_at"`
+	ExpiresAt      *time.Time             `json:"expires_at"`
+	Active         *bool                  `json:"active"`
+}
+
+type PromoCodeResponse struct {
+	ID             uint                   `json:"id"`
+	Code           string                 `json:"code"`
+	Description    string                 `json:"description"`
+	MinAmounts     map[string]json.Number `json:"min_amounts"`
+	BonusPercent   int                    `json:"bonus_percent"`
+	MaxUses        int                    `json:"max_uses"`
+	MaxUsesPerUser int                    `json:"max_uses_per_user"`
+	Uses           int64                  `json:"uses"`
+	StartsAt       *time.Time             `json:"starts_at"`
+	ExpiresAt      *time.Time             `json:"expires_at"`
+	Active         bool                   `json:"active"`
+	CreatedAt      time.Time              `json:"created_at"`
+}
+
+func newPromoCodeResponse(promo *models.PromoCode, uses int64) PromoCodeResponse {
+	return PromoCodeResponse{
+		ID:             promo.ID,
+		Code:           promo.Code,
+		Description:    promo.Description,
+		MinAmounts:     decimalAmounts(promo.MinAmounts),
+		BonusPercent:   promo.BonusPercent,
+		MaxUses:        promo.MaxUses,
+		MaxUsesPerUser: promo.MaxUsesPerUser,
+		Uses:           uses,
+		StartsAt:       promo.StartsAt,
+		ExpiresAt:      promo.ExpiresAt,
+		Active:         promo.Active,
+		CreatedAt:      promo.CreatedAt,
+	}
+}
+
+// ListPromoCodes returns every promo code with how often it was redeemed
+func (h *PromoCodeHandler) ListPromoCodes(c *gin.Context) {
+	var promos []models.PromoCode
+	if err := h.db.Order("id DESC").Find(&promos).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetPromoCodes))
+		return
+	}
+
+	ids := make([]uint, len(promos))
+	for i, promo := range promos {
+		ids[i] = promo.ID
+	}
+	uses, err := h.promoService.Uses(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetPromoCodes))
+		return
+	}
+
+	response := make([]PromoCodeResponse, len(promos))
+	for i := range promos {
+		response[i] = newPromoCodeResponse(&promos[i], uses[promos[i].ID])
+	}
+
+	c.JSON(http.StatusOK, gin.H{"promo_codes": response})
+}
+
+func (h *PromoCodeHandler) GetPromoCode(c *gin.Context) {
+	promo, ok := h.findPromoCode(c)
+	if !ok {
+		return
+	}
+
+	uses, err := h.promoService.Uses([]uint{promo.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetPromoCodes))
+		return
+	}
+
+	c.JSON(http.StatusOK, newPromoCodeResponse(promo, uses[promo.ID]))
+}
+
+func (h *PromoCodeHandler) CreatePromoCode(c *gin.Context) {
+	promo := models.PromoCode{Active: true}
+	if !h.bindPromoCode(c, &promo) {
+		return
+	}
+
+	if err := h.db.Create(&promo).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSavePromoCode))
+		return
+	}
+
+	c.JSON(http.StatusCreated, newPromoCodeResponse(&promo, 0))
+}
+
+func (h *PromoCodeHandler) UpdatePromoCode(c *gin.Context) {
+	promo, ok := h.findPromoCode(c)
+	if !ok {
+		return
+	}
+
+	if !h.bindPromoCode(c, promo) {
+		return
+	}
+
+	if err := h.db.Save(promo).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSavePromoCode))
+		return
+	}
+
+	uses, err := h.promoService.Uses([]uint{promo.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetPromoCodes))
+		return
+	}
+
+	c.JSON(http.StatusOK, newPromoCodeResponse(promo, uses[promo.ID]))
+}
+
+// DeletePromoCode removes a code no payment has used. Redeemed codes are kept so their
+// payments still show where their bonus came from, and can be deactivated instead.
+func (h *PromoCodeHandler) DeletePromoCode(c *gin.Context) {
+	promo, ok := h.findPromoCode(c)
+	if !ok {
+		return
+	}
+
+	var redeemed int64
+	if err := h.db.Model(&models.Payment{}).Where("promo_code_id = ?", promo.ID).Count(&redeemed).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSavePromoCode))
+		return
+	}
+	if redeemed > 0 {
+		c.JSON(http.StatusConflict, errors.NewAPIError(http.StatusConflict, constants.ErrPromoCodeInUse))
+		return
+	}
+
+	if err := h.db.Delete(promo).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSavePromoCode))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *PromoCodeHandler) findPromoCode(c *gin.Context) (*models.PromoCode, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrPromoCodeNotFound))
+		return nil, false
+	}
+
+	var promo models.PromoCode
+	if err := h.db.First(&promo, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrPromoCodeNotFound))
+		return nil, false
+	}
+	return &promo, true
+}
+
+// bindPromoCode applies a PromoCodeRequest to promo, writing a 400 or 409 response and
+// returning false if it is invalid. A missing active flag is left unchanged.
+func (h *PromoCodeHandler) bindPromoCode(c *gin.Context, promo *models.PromoCode) bool {
+	var req PromoCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, err.Error()))
+		return false
+	}
+
+	code := services.NormalizeCode(req.Code)
+	if !promoCodePattern.MatchString(code) {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidPromoCode))
+		return false
+	}
+
+	var taken int64
+	if err := h.db.Model(&models.PromoCode{}).Where("code = ? AND id <> ?", code, promo.ID).Count(&taken).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSavePromoCode))
+		return false
+	}
+	if taken > 0 {
+		c.JSON(http.StatusConflict, errors.NewAPIError(http.StatusConflict, constants.ErrPromoCodeTaken))
+		return false
+	}
+
+	minAmounts, err := parseAmounts(req.MinAmounts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidAmount))
+		return false
+	}
+
+	if req.StartsAt != nil && req.ExpiresAt != nil && !req.ExpiresAt.After(*req.StartsAt) {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidPromoCodeDates))
+		return false
+	}
+
+	promo.Code = code
+	promo.Description = req.Description
+	promo.MinAmounts = minAmounts
+	promo.BonusPercent = req.BonusPercent
+	promo.MaxUses = req.MaxUses
+	promo.MaxUsesPerUser = req.MaxUsesPerUser
+	promo.StartsAt = req.StartsAt
+	promo.ExpiresAt = req.ExpiresAt
+	if req.Active != nil {
+		promo.Active = *req.Active
+	}
+	return true
+}
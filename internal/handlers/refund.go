@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"log"
+	"net/http"
+	"strconv"
+	"vinak/internal/middleware"
+	"vinak/internal/models"
+	"vinak/internal/services"
+	"vinak/pkg/constants"
+	"vinak/pkg/errors"
+	"vinak/pkg/money"
+	"vinak/pkg/payment"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RefundPaymentRequest struct {
+	// Amount is in the payment's currency, omitted for a full refund
+	Amount json.Number `json:"amount"`
+	Reason string      `json:"reason" binding:"max=255"`
+	// Manual records money returned outside the gateway, required for NowPayments
+	Manual bool `json:"manual"`
+}
+
+func (h *PaymentHandler) RefundPayment(c *gin.Context) {
+	paymentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidPaymentID))
+		return
+	}
+
+	var req RefundPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	var record models.Payment
+	if err := h.db.First(&record, paymentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrPaymentNotFound))
+		return
+	}
+
+	refundReq := services.RefundRequest{
+		PaymentID: record.ID,
+		Reason:    req.Reason,
+		Manual:    req.Manual,
+		AdminID:   middleware.CurrentUser(c).ID,
+	}
+	if req.Amount != "" {
+		amount, err := money.Parse(req.Amount.String(), record.Currency)
+		if err != nil || amount.Amount <= 0 {
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidRefundAmount))
+			return
+		}
+		refundReq.Amount = &amount
+	}
+
+	refund, updated, err := h.paymentService.Refund(refundReq)
+	var gatewayErr *errors.PaymentGatewayError
+	var unsupportedErr *payment.UnsupportedRefundError
+	switch {
+	case err == nil:
+	case stderrors.Is(err, services.ErrPaymentNotRefundable):
+		c.JSON(http.StatusConflict, errors.NewAPIError(http.StatusConflict, constants.ErrPaymentNotRefundable))
+		return
+	case stderrors.Is(err, services.ErrRefundExceedsBalance):
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrRefundExceedsBalance))
+		return
+	case stderrors.As(err, &unsupportedErr):
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, err.Error()))
+		return
+	case stderrors.Is(err, payment.ErrPartialRefundUnsupported):
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrPartialRefundUnsupported))
+		return
+	case stderrors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrPaymentNotFound))
+		return
+	case stderrors.As(err, &gatewayErr):
+		log.Printf("Failed to refund %s payment %d: %v", record.Gateway, record.ID, err)
+		c.JSON(http.StatusBadGateway, errors.NewAPIError(http.StatusBadGateway, err.Error()))
+		return
+	default:
+		log.Printf("Failed to refund payment %d: %v", record.ID, err)
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToRefundPayment))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"refund_id":       refund.ID,
+		"payment_id":      updated.ID,
+		"status":          refund.Status,
+		"amount":          refund.Money().Decimal(),
+		"currency":        refund.Currency,
+		"manual":          refund.Manual,
+		"payment_status":  updated.Status,
+		"refunded_amount": updated.RefundedMoney().Decimal(),
+	})
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"vinak/internal/middleware"
+	"vinak/internal/models"
+	"vinak/internal/services"
+	"vinak/pkg/constants"
+	"vinak/pkg/errors"
+	"vinak/pkg/money"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// streamHeartbeat keeps idle streams from being closed by proxies
+const streamHeartbeat = 15 * time.Second
+
+type StreamHandler struct {
+	db            *gorm.DB
+	paymentEvents *services.PaymentEventService
+	donationFeed  *services.DonationFeedService
+	streamTokens  *services.StreamTokenService
+}
+
+func NewStreamHandler(db *gorm.DB, paymentEvents *services.PaymentEventService, donationFeed *services.DonationFeedService, streamTokens *services.StreamTokenService) *StreamHandler {
+	return &StreamHandler{
+		db:            db,
+		paymentEvents: paymentEvents,
+		donationFeed:  donationFeed,
+		streamTokens:  streamTokens,
+	}
+}
+
+// CreateStreamToken issues a short-lived token for opening the event stream of one of
+// the caller's payments from a browser, as /api/payments/:id/events?token=...
+func (h *StreamHandler) CreateStreamToken(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+
+	paymentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidPaymentID))
+		return
+	}
+
+	var record models.Payment
+	if err := h.db.Where("id = ? AND user_id = ?", paymentID, user.ID).First(&record).Error; err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrPaymentNotFound))
+		return
+	}
+
+	token := h.streamTokens.Issue(record.ID, user.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token.Token,
+		"expires_at": token.ExpiresAt,
+	})
+}
+
+// PaymentEvents streams the status changes of one of the caller's payments as
+// Server-Sent Events. The current status is sent first as a "status" event, followed
+// by a "transition" event for every change.
+func (h *StreamHandler) PaymentEvents(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+
+	paymentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidPaymentID))
+		return
+	}
+
+	var record models.Payment
+	if err := h.db.Where("id = ? AND user_id = ?", paymentID, user.ID).First(&record).Error; err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrPaymentNotFound))
+		return
+	}
+
+	events, err := h.paymentEvents.Subscribe(c.Request.Context(), record.ID)
+	if err != nil {
+		log.Printf("Failed to subscribe to payment %d: %v", record.ID, err)
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSubscribe))
+		return
+	}
+
+	// Re-read now that the subscription is live so a transition in between isn't lost
+	if err := h.db.First(&record, record.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetPayments))
+		return
+	}
+
+	setStreamHeaders(c)
+	c.SSEvent("status", newPaymentResponse(&record))
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent("transition", event)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("ping", time.Now().Unix())
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// DonationFeed streams completed donations to a stream overlay as "donation" events. The
+// overlay authenticates with its token in the query string, as browser sources in OBS
+// can't set headers. Its settings are re-read before every event and heartbeat, so edits
+// apply while it is connected and deactivating it closes the stream.
+func (h *StreamHandler) DonationFeed(c *gin.Context) {
+	token := c.Query(constants.QueryOverlayToken)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, errors.NewAPIError(http.StatusUnauthorized, constants.ErrOverlayTokenRequired))
+		return
+	}
+
+	overlay, err := h.findOverlay(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, errors.NewAPIError(http.StatusUnauthorized, constants.ErrInvalidOverlayToken))
+		return
+	}
+
+	donations, err := h.donationFeed.Subscribe(c.Request.Context())
+	if err != nil {
+		log.Printf("Failed to subscribe overlay %d to the donation feed: %v", overlay.ID, err)
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSubscribe))
+		return
+	}
+
+	setStreamHeaders(c)
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case donation, ok := <-donations:
+			if !ok {
+				return false
+			}
+			if overlay, err = h.findOverlay(token); err != nil {
+				return false
+			}
+			if overlay.Accepts(money.New(donation.AmountMinor, donation.Currency)) {
+				c.SSEvent("donation", donation)
+			}
+			return true
+		case <-heartbeat.C:
+			if overlay, err = h.findOverlay(token); err != nil {
+				return false
+			}
+			c.SSEvent("ping", time.Now().Unix())
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// findOverlay returns the active overlay with token
+func (h *StreamHandler) findOverlay(token string) (*models.Overlay, error) {
+	var overlay models.Overlay
+	if err := h.db.Where("token = ? AND active = ?", token, true).First(&overlay).Error; err != nil {
+		return nil, err
+	}
+	return &overlay, nil
+}
+
+func setStreamHeaders(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Stop nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+}
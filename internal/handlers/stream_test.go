@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"vinak/internal/models"
+	"vinak/internal/services"
+	"vinak/pkg/constants"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestDonationFeedFollowsOverlaySettings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	overlay := models.Overlay{
+		Name:       "stream",
+		Token:      "overlay-token",
+		MinAmounts: map[string]int64{constants.CurrencyUSD: 50},
+		Active:     true,
+	}
+	if err := db.Create(&overlay).Error; err != nil {
+		t.Fatalf("failed to create overlay: %v", err)
+	}
+
+	feed := services.NewDonationFeedService(client)
+	handler := NewStreamHandler(db, nil, feed, nil)
+	router := gin.New()
+	router.GET("/feed", handler.DonationFeed)
+	api := httptest.NewServer(router)
+	t.Cleanup(api.Close)
+
+	response, err := http.Get(api.URL + "/feed?" + constants.QueryOverlayToken + "=" + overlay.Token)
+	if err != nil {
+		t.Fatalf("failed to open the feed: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", response.StatusCode, http.StatusOK)
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	publish := func(paymentID uint, amount int64) {
+		t.Helper()
+		if err := feed.Publish(services.Donation{PaymentID: paymentID, AmountMinor: amount, Currency: constants.CurrencyUSD}); err != nil {
+			t.Fatalf("failed to publish donation %d: %v", paymentID, err)
+		}
+	}
+
+	timeout := time.After(5 * time.Second)
+	nextDonation := func() string {
+		t.Helper()
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatalf("feed closed while waiting for a donation")
+				}
+				if strings.HasPrefix(line, "data:") {
+					return line
+				}
+			case <-timeout:
+				t.Fatalf("no donation was streamed")
+			}
+		}
+	}
+
+	publish(1, 100)
+	if donation := nextDonation(); !strings.Contains(donation, `"payment_id":1`) {
+		t.Fatalf("streamed %s, want payment 1", donation)
+	}
+
+	// A minimum raised while connected applies to the next donation
+	if err := db.Model(&overlay).Updates(models.Overlay{MinAmounts: map[string]int64{constants.CurrencyUSD: 500}}).Error; err != nil {
+		t.Fatalf("failed to update overlay: %v", err)
+	}
+	publish(2, 100)
+	publish(3, 500)
+	if donation := nextDonation(); !strings.Contains(donation, `"payment_id":3`) {
+		t.Errorf("streamed %s, want payment 3", donation)
+	}
+
+	// Deactivating the overlay closes its stream
+	if err := db.Model(&overlay).Update("active", false).Error; err != nil {
+		t.Fatalf("failed to deactivate overlay: %v", err)
+	}
+	publish(4, 100)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if strings.HasPrefix(line, "data:") {
+				t.Errorf("deactivated overlay was sent %s", line)
+			}
+		case <-timeout:
+			t.Fatalf("feed of a deactivated overlay stayed open")
+		}
+	}
+}
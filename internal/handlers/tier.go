@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"vinak/internal/middleware"
+	"vinak/internal/models"
+	"vinak/internal/services"
+	"vinak/pkg/constants"
+	"vinak/pkg/errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TierHandler struct {
+	db          *gorm.DB
+	tierService *services.TierService
+}
+
+func NewTierHandler(db *gorm.DB, tierService *services.TierService) *TierHandler {
+	return &TierHandler{
+		db:          db,
+		tierService: tierService,
+	}
+}
+
+type TierRequest struct {
+	Name   string `json:"name" binding:"required,max=100"`
+	Reward string `json:"reward"`
+	Level  int    `json:"level"`
+	// Thresholds maps a currency to the total that reaches the tier, as a decimal
+	Thresholds map[string]json.Number `json:"thresholds" binding:"required"`
+}
+
+type UpdateTierAwardRequest struct {
+	FulfilmentStatus string `json:"fulfilment_status" binding:"required"`
+	FulfilmentNote   string `json:"fulfilment_note" binding:"max=1000"`
+}
+
+type TierResponse struct {
+	ID         uint                   `json:"id"`
+	Name       string                 `json:"name"`
+	Reward     string                 `json:"reward"`
+	Level      int                    `json:"level"`
+	Thresholds map[string]json.Number `json:"thresholds"`
+}
+
+type TierAwardResponse struct {
+	ID               uint         `json:"id"`
+	Tier             TierResponse `json:"tier"`
+	FulfilmentStatus string       `json:"fulfilment_status"`
+	FulfilledAt      *time.Time   `json:"fulfilled_at"`
+	AwardedAt        time.Time    `json:"awarded_at"`
+}
+
+func newTierResponse(tier *models.Tier) TierResponse {
+	return TierResponse{
+		ID:         tier.ID,
+		Name:       tier.Name,
+		Reward:     tier.Reward,
+		Level:      tier.Level,
+		Thresholds: decimalAmounts(tier.Thresholds),
+	}
+}
+
+func newTierAwardResponse(award *models.TierAward) TierAwardResponse {
+	return TierAwardResponse{
+		ID:               award.ID,
+		Tier:             newTierResponse(&award.Tier),
+		FulfilmentStatus: award.FulfilmentStatus,
+		FulfilledAt:      award.FulfilledAt,
+		AwardedAt:        award.CreatedAt,
+	}
+}
+
+// GetMyTier returns the caller's highest tier, every tier they reached with its reward's
+// fulfilment status, and what is still missing per currency to reach the next one.
+// Reaching the threshold in any one currency is enough, so each remaining amount is an
+// alternative to the others, and zero once its threshold is met.
+func (h *TierHandler) GetMyTier(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+
+	awards, err := h.tierService.Awards(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetTiers))
+		return
+	}
+
+	totals, err := h.tierService.Totals(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetTiers))
+		return
+	}
+
+	var current *TierResponse
+	level := math.MinInt
+	if len(awards) > 0 {
+		tier := newTierResponse(&awards[0].Tier)
+		current = &tier
+		level = awards[0].Tier.Level
+	}
+
+	next, err := h.tierService.NextTier(level)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetTiers))
+		return
+	}
+
+	awardsResponse := make([]TierAwardResponse, len(awards))
+	for i := range awards {
+		awardsResponse[i] = newTierAwardResponse(&awards[i])
+	}
+
+	response := gin.H{
+		"tier":      current,
+		"awards":    awardsResponse,
+		"totals":    decimalAmounts(totals),
+		"next_tier": nil,
+	}
+	if next != nil {
+		remaining := make(map[string]int64, len(next.Thresholds))
+		for currency, threshold := range next.Thresholds {
+			remaining[currency] = max(threshold-totals[currency], 0)
+		}
+		response["next_tier"] = newTierResponse(next)
+		response["remaining"] = decimalAmounts(remaining)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *TierHandler) ListTiers(c *gin.Context) {
+	var tiers []models.Tier
+	if err := h.db.Order("level").Find(&tiers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetTiers))
+		return
+	}
+
+	response := make([]TierResponse, len(tiers))
+	for i := range tiers {
+		response[i] = newTierResponse(&tiers[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tiers": response})
+}
+
+func (h *TierHandler) CreateTier(c *gin.Context) {
+	var tier models.Tier
+	if !h.saveTier(c, &tier) {
+		return
+	}
+	c.JSON(http.StatusCreated, newTierResponse(&tier))
+}
+
+func (h *TierHandler) UpdateTier(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrTierNotFound))
+		return
+	}
+
+	var tier models.Tier
+	if err := h.db.First(&tier, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrTierNotFound))
+		return
+	}
+
+	if !h.saveTier(c, &tier) {
+		return
+	}
+	c.JSON(http.StatusOK, newTierResponse(&tier))
+}
+
+// saveTier applies a TierRequest to tier and stores it, then awards it to donors who
+// already qualify. It writes an error response and returns false on failure.
+func (h *TierHandler) saveTier(c *gin.Context, tier *models.Tier) bool {
+	var req TierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, err.Error()))
+		return false
+	}
+
+	thresholds, err := parseAmounts(req.Thresholds)
+	if err != nil || len(thresholds) == 0 {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidAmount))
+		return false
+	}
+
+	var taken int64
+	if err := h.db.Model(&models.Tier{}).Where("level = ? AND id <> ?", req.Level, tier.ID).Count(&taken).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSaveTier))
+		return false
+	}
+	if taken > 0 {
+		c.JSON(http.StatusConflict, errors.NewAPIError(http.StatusConflict, constants.ErrTierLevelTaken))
+		return false
+	}
+
+	tier.Name = req.Name
+	tier.Reward = req.Reward
+	tier.Level = req.Level
+	tier.Thresholds = thresholds
+	if err := h.db.Save(tier).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSaveTier))
+		return false
+	}
+
+	if err := h.tierService.AssignAll(); err != nil {
+		log.Printf("Failed to award tier %d to qualifying donors: %v", tier.ID, err)
+	}
+	return true
+}
+
+// UpdateTierAward records the fulfilment of a donor's reward
+func (h *TierHandler) UpdateTierAward(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrTierAwardNotFound))
+		return
+	}
+
+	var req UpdateTierAwardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	if !models.IsFulfilmentStatus(req.FulfilmentStatus) {
+		c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidFulfilmentStatus))
+		return
+	}
+
+	var award models.TierAward
+	if err := h.db.Joins("Tier").First(&award, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, errors.NewAPIError(http.StatusNotFound, constants.ErrTierAwardNotFound))
+		return
+	}
+
+	award.FulfilmentStatus = req.FulfilmentStatus
+	award.FulfilmentNote = req.FulfilmentNote
+	award.FulfilledAt = nil
+	if req.FulfilmentStatus == constants.FulfilmentStatusFulfilled {
+		now := time.Now()
+		award.FulfilledAt = &now
+	}
+	if err := h.db.Omit("Tier").Save(&award).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToSaveTier))
+		return
+	}
+
+	c.JSON(http.StatusOK, newTierAwardResponse(&award))
+}
+
+// ExportTierAwards writes every donor who reached a tier as CSV, highest tier first, for
+// sending out rewards. tier_id and fulfilment_status narrow the export.
+func (h *TierHandler) ExportTierAwards(c *gin.Context) {
+	query := h.db.Model(&models.TierAward{}).
+		Select("tier_awards.id, tiers.level, tiers.name AS tier, users.id AS user_id, users.name, users.email, " +
+			"users.instagram_id, tier_awards.fulfilment_status, tier_awards.fulfilment_note, " +
+			"tier_awards.fulfilled_at, tier_awards.created_at").
+		Joins("JOIN tiers ON tiers.id = tier_awards.tier_id").
+		Joins("JOIN users ON users.id = tier_awards.user_id")
+
+	if tierID := c.Query("tier_id"); tierID != "" {
+		query = query.Where("tier_awards.tier_id = ?", tierID)
+	}
+	if status := c.Query("fulfilment_status"); status != "" {
+		if !models.IsFulfilmentStatus(status) {
+			c.JSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidFulfilmentStatus))
+			return
+		}
+		query = query.Where("tier_awards.fulfilment_status = ?", status)
+	}
+
+	var rows []struct {
+		ID               uint
+		Level            int
+		Tier             string
+		UserID           uint
+		Name             string
+		Email            string
+		InstagramID      string
+		FulfilmentStatus string
+		FulfilmentNote   string
+		FulfilledAt      *time.Time
+		CreatedAt        time.Time
+	}
+	if err := query.Order("tiers.level DESC, tier_awards.created_at").Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToGetTiers))
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="tier-awards.csv"`)
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"award_id", "tier_level", "tier", "user_id", "name", "email", "instagram_id", "fulfilment_status", "fulfilment_note", "fulfilled_at", "awarded_at"})
+	for _, row := range rows {
+		fulfilledAt := ""
+		if row.FulfilledAt != nil {
+			fulfilledAt = row.FulfilledAt.UTC().Format(time.RFC3339)
+		}
+		writer.Write([]string{
+			strconv.FormatUint(uint64(row.ID), 10),
+			strconv.Itoa(row.Level),
+			csvCell(row.Tier),
+			strconv.FormatUint(uint64(row.UserID), 10),
+			csvCell(row.Name),
+			csvCell(row.Email),
+			csvCell(row.InstagramID),
+			row.FulfilmentStatus,
+			csvCell(row.FulfilmentNote),
+			fulfilledAt,
+			row.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("Failed to write tier award export: %v", err)
+	}
+}
+
+// csvCell stops spreadsheet apps from running donor-supplied text as a formula
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -5,9 +5,12 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"vinak/internal/middleware"
 	"vinak/internal/models"
 	"vinak/internal/services"
+	"vinak/pkg/constants"
 	"vinak/pkg/email"
 
 	"github.com/gin-gonic/gin"
@@ -18,13 +21,17 @@ type UserHandler struct {
 	db           *gorm.DB
 	otpService   *services.OTPService
 	emailService *email.EmailService
+	leaderboard  *services.LeaderboardService
+	giftService  *services.GiftService
 }
 
-func NewUserHandler(db *gorm.DB, otpService *services.OTPService, emailService *email.EmailService) *UserHandler {
+func NewUserHandler(db *gorm.DB, otpService *services.OTPService, emailService *email.EmailService, leaderboard *services.LeaderboardService, giftService *services.GiftService) *UserHandler {
 	return &UserHandler{
 		db:           db,
 		otpService:   otpService,
 		emailService: emailService,
+		leaderboard:  leaderboard,
+		giftService:  giftService,
 	}
 }
 
@@ -39,6 +46,10 @@ type VerifyOTPRequest struct {
 	Name        string `json:"name" binding:"required"`
 }
 
+type UpdatePrivacyRequest struct {
+	Privacy string `json:"privacy" binding:"required"`
+}
+
 func (h *UserHandler) SendOTP(c *gin.Context) {
 	var req SendOTPRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -126,8 +137,39 @@ func (h *UserHandler) VerifyOTPAndCreateUser(c *gin.Context) {
 		return
 	}
 
+	// Credit the new user with donations made in their name before they signed up
+	claimed, err := h.giftService.Claim(&user)
+	if err != nil {
+		log.Printf("Failed to claim gifts for user %d: %v", user.ID, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "User created successfully",
-		"api_key": apiKeyStr,
+		"message":       "User created successfully",
+		"api_key":       apiKeyStr,
+		"claimed_gifts": claimed,
 	})
 }
+
+// UpdatePrivacy sets how the caller is shown on the leaderboard, overlays and
+// notifications: public, name_only or anonymous.
+func (h *UserHandler) UpdatePrivacy(c *gin.Context) {
+	var req UpdatePrivacyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !models.IsPrivacySetting(req.Privacy) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": constants.ErrInvalidPrivacy})
+		return
+	}
+
+	user := middleware.CurrentUser(c)
+	if err := h.db.Model(user).Update("privacy", req.Privacy).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": constants.ErrFailedToUpdatePrivacy})
+		return
+	}
+	h.leaderboard.Touch()
+
+	c.JSON(http.StatusOK, gin.H{"privacy": req.Privacy})
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"io"
+	"log"
+	"net/http"
+	"vinak/internal/services"
+	"vinak/pkg/constants"
+	"vinak/pkg/errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxIdempotencyKeyLength = 255
+	idempotencyKeptKey      = "idempotency_kept"
+)
+
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// Idempotency replays the original response when a request is retried with the same
+// Idempotency-Key header. Keys are scoped to the caller's API key, and reusing one with
+// a different body is rejected with 409. Requests without the header pass through.
+func Idempotency(idempotencyService *services.IdempotencyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(constants.HeaderIdempotencyKey)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, constants.ErrInvalidIdempotencyKey))
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errors.NewAPIError(http.StatusBadRequest, "Failed to read request body"))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := hashOf([]byte(c.GetHeader(constants.HeaderAPIKey)))
+		requestHash := hashOf([]byte(c.Request.Method+" "+c.FullPath()+"\n"), body)
+
+		stored, err := idempotencyService.Begin(scope, key, requestHash)
+		switch {
+		case stderrors.Is(err, services.ErrIdempotencyKeyReused), stderrors.Is(err, services.ErrRequestInProgress):
+			c.AbortWithStatusJSON(http.StatusConflict, errors.NewAPIError(http.StatusConflict, err.Error()))
+			return
+		case err != nil:
+			log.Printf("Failed to check idempotency key: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, errors.NewAPIError(http.StatusInternalServerError, constants.ErrFailedToCheckIdempotencyKey))
+			return
+		case stored != nil:
+			c.Header(constants.HeaderIdempotentReplayed, "true")
+			c.Data(stored.StatusCode, "application/json; charset=utf-8", stored.Body)
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// Server errors aren't cached so the client can retry them, unless retrying would
+		// repeat something the failed request already did
+		if recorder.Status() >= http.StatusInternalServerError && !c.GetBool(idempotencyKeptKey) {
+			err = idempotencyService.Release(scope, key)
+		} else {
+			err = idempotencyService.Complete(scope, key, requestHash, recorder.Status(), recorder.body.Bytes())
+		}
+		if err != nil {
+			log.Printf("Failed to store idempotency key: %v", err)
+		}
+	}
+}
+
+// KeepIdempotencyKey stores the response to the current request for replay even if it
+// ends in a server error. Handlers call it once they changed something a retry must not
+// repeat, such as creating an order at a payment gateway.
+func KeepIdempotencyKey(c *gin.Context) {
+	c.Set(idempotencyKeptKey, true)
+}
+
+func hashOf(parts ...[]byte) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write(part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
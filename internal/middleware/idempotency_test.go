@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"vinak/internal/services"
+	"vinak/pkg/constants"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+func newIdempotencyRouter(t *testing.T, handler gin.HandlerFunc) *gin.Engine {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	router := gin.New()
+	router.POST("/payments", Idempotency(services.NewIdempotencyService(client, time.Hour, time.Minute)), handler)
+	return router
+}
+
+func sendIdempotent(router *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(body))
+	request.Header.Set(constants.HeaderAPIKey, "api-key")
+	if key != "" {
+		request.Header.Set(constants.HeaderIdempotencyKey, key)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		// status the handler responds with, and whether it marks the key as kept
+		status int
+		keep   bool
+		// whether a retry runs the handler again
+		retried bool
+	}{
+		{"success is replayed", http.StatusOK, false, false},
+		{"client error is replayed", http.StatusBadRequest, false, false},
+		{"server error is retried", http.StatusInternalServerError, false, true},
+		{"server error after a kept key is replayed", http.StatusInternalServerError, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			router := newIdempotencyRouter(t, func(c *gin.Context) {
+				calls++
+				if tt.keep {
+					KeepIdempotencyKey(c)
+				}
+				c.JSON(tt.status, gin.H{"call": calls})
+			})
+
+			first := sendIdempotent(router, "key", `{"amount":"10"}`)
+			retry := sendIdempotent(router, "key", `{"amount":"10"}`)
+
+			wantCalls := 1
+			if tt.retried {
+				wantCalls = 2
+			}
+			if calls != wantCalls {
+				t.Fatalf("handler ran %d times, want %d", calls, wantCalls)
+			}
+			if retry.Code != tt.status {
+				t.Errorf("retry status = %d, want %d", retry.Code, tt.status)
+			}
+
+			replayed := retry.Header().Get(constants.HeaderIdempotentReplayed) == "true"
+			if replayed == tt.retried {
+				t.Errorf("retry replayed = %v, want %v", replayed, !tt.retried)
+			}
+			if replayed && retry.Body.String() != first.Body.String() {
+				t.Errorf("replayed body = %s, want %s", retry.Body.String(), first.Body.String())
+			}
+		})
+	}
+}
+
+func TestIdempotencyMiddlewareConflicts(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	router := newIdempotencyRouter(t, func(c *gin.Context) {
+		if c.GetHeader("X-Block") != "" {
+			close(started)
+			<-release
+		}
+		c.JSON(http.StatusOK, gin.H{})
+	})
+
+	// A retry while the first request is still running
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		request := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(`{"amount":"10"}`))
+		request.Header.Set(constants.HeaderAPIKey, "api-key")
+		request.Header.Set(constants.HeaderIdempotencyKey, "key")
+		request.Header.Set("X-Block", "true")
+		router.ServeHTTP(httptest.NewRecorder(), request)
+	}()
+	<-started
+	if response := sendIdempotent(router, "key", `{"amount":"10"}`); response.Code != http.StatusConflict {
+		t.Errorf("retry in progress status = %d, want %d", response.Code, http.StatusConflict)
+	}
+	close(release)
+	<-done
+
+	// The same key with another body
+	if response := sendIdempotent(router, "key", `{"amount":"20"}`); response.Code != http.StatusConflict {
+		t.Errorf("reused key status = %d, want %d", response.Code, http.StatusConflict)
+	}
+
+	// Requests without a key are never replayed
+	if response := sendIdempotent(router, "", `{"amount":"10"}`); response.Header().Get(constants.HeaderIdempotentReplayed) != "" {
+		t.Errorf("request without a key was replayed")
+	}
+}
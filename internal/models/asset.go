@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// Asset is an album file supporters can download. Key locates it in the asset store and
+// FileName is what it is saved as.
+type Asset struct {
+	ID          uint   `gorm:"primary_key;auto_increment"`
+	Title       string `gorm:"not null"`
+	FileName    string `gorm:"not null"`
+	Key         string `gorm:"not null"`
+	ContentType string `gorm:"not null;default:''"`
+	Size        int64  `gorm:"not null;default:0"`
+	Position    int    `gorm:"not null;default:0"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// AssetDownload counts how often a user downloaded an asset
+type AssetDownload struct {
+	ID               uint `gorm:"primary_key;auto_increment"`
+	UserID           uint `gorm:"not null;uniqueIndex:idx_asset_downloads_user_asset"`
+	AssetID          uint `gorm:"not null;uniqueIndex:idx_asset_downloads_user_asset"`
+	Count            int  `gorm:"not null;default:0"`
+	LastDownloadedAt time.Time
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+	"vinak/pkg/constants"
+)
+
+// Campaign is a fundraising goal donations can be made towards, with a target per
+// currency in minor units. A nil EndsAt keeps it running until it is closed.
+type Campaign struct {
+	ID          uint             `gorm:"primary_key;auto_increment"`
+	Slug        string           `gorm:"unique;not null"`
+	Title       string           `gorm:"not null"`
+	Description string           `gorm:"not null;default:''"`
+	Targets     map[string]int64 `gorm:"serializer:json;type:jsonb"`
+	StartsAt    time.Time        `gorm:"not null"`
+	EndsAt      *time.Time       `gorm:"default:null"`
+	Status      string           `gorm:"not null;default:'draft'"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// AcceptsDonations reports whether the campaign is active and running at t
+func (c *Campaign) AcceptsDonations(t time.Time) bool {
+	return c.Status == constants.CampaignStatusActive && !t.Before(c.StartsAt) && (c.EndsAt == nil || t.Before(*c.EndsAt))
+}
+
+func IsCampaignStatus(status string) bool {
+	switch status {
+	case constants.CampaignStatusDraft, constants.CampaignStatusActive, constants.CampaignStatusClosed:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// ExchangeRate is the value of one major unit of Currency in ReferenceCurrency from
+// FetchedAt until the next rate for the pair. Old rates are kept so donations can be
+// converted at the rate of the day they were made.
+type ExchangeRate struct {
+	ID                uint      `gorm:"primary_key;auto_increment"`
+	Currency          string    `gorm:"not null;index:idx_exchange_rates_pair,priority:1"`
+	ReferenceCurrency string    `gorm:"not null;index:idx_exchange_rates_pair,priority:2"`
+	Rate              float64   `gorm:"type:numeric(38,18);not null"`
+	Source            string    `gorm:"not null"`
+	FetchedAt         time.Time `gorm:"not null;index:idx_exchange_rates_pair,priority:3"`
+	CreatedAt         time.Time
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
+
+	"gorm.io/gorm"
+)
+
+// Migrate brings the schema up to date and converts rows written by older versions.
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&User{}, &Payment{}, &PaymentLog{}, &Refund{}, &Overlay{}, &ExchangeRate{}, &Campaign{}, &Tier{}, &TierAward{}, &Asset{}, &AssetDownload{}, &PromoCode{}); err != nil {
+		return err
+	}
+
+	if err := migrateGatewayReferences(db); err != nil {
+		return err
+	}
+
+	if err := migrateAmountsToMinorUnits(db); err != nil {
+		return err
+	}
+
+	// Rows from before charged amounts were tracked were charged exactly what was requested
+	if err := db.Exec("UPDATE payments SET charged_amount_minor = amount_minor, charged_currency = currency WHERE charged_currency = ''").Error; err != nil {
+		return err
+	}
+
+	// Payments refunded before refunds were tracked were refunded in full
+	if err := db.Exec("UPDATE payments SET refunded_amount_minor = amount_minor WHERE status = ? AND refunded_amount_minor = 0", constants.PaymentStatusRefunded).Error; err != nil {
+		return err
+	}
+
+	// Payments from before gifts count for their donor
+	return db.Exec("UPDATE payments SET credited_user_id = user_id WHERE credited_user_id IS NULL AND recipient_email = '' AND recipient_instagram_id = ''").Error
+}
+
+// migrateGatewayReferences moves the per-gateway id columns into gateway/gateway_reference.
+func migrateGatewayReferences(db *gorm.DB) error {
+	legacyColumns := []struct {
+		column  string
+		gateway string
+	}{
+		{"pay_pal_order_id", constants.PaymentGatewayPayPal},
+		{"now_payments_payment_id", constants.PaymentGatewayNowPayments},
+		{"zarinpal_authority", constants.PaymentGatewayZarinpal},
+	}
+
+	migrator := db.Migrator()
+	for _, legacy := range legacyColumns {
+		if !migrator.HasColumn(&Payment{}, legacy.column) {
+			continue
+		}
+
+		query := fmt.Sprintf(
+			"UPDATE payments SET gateway = ?, gateway_reference = %s WHERE %s IS NOT NULL AND gateway_reference IS NULL",
+			legacy.column, legacy.column,
+		)
+		if err := db.Exec(query, legacy.gateway).Error; err != nil {
+			return err
+		}
+		if err := migrator.DropColumn(&Payment{}, legacy.column); err != nil {
+			return err
+		}
+	}
+
+	if migrator.HasColumn(&Payment{}, "zarinpal_ref_id") {
+		if err := db.Exec("UPDATE payments SET gateway_transaction_id = zarinpal_ref_id WHERE zarinpal_ref_id IS NOT NULL AND gateway_transaction_id IS NULL").Error; err != nil {
+			return err
+		}
+		if err := migrator.DropColumn(&Payment{}, "zarinpal_ref_id"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateAmountsToMinorUnits converts the old float amount column into exact minor units.
+func migrateAmountsToMinorUnits(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasColumn(&Payment{}, "amount") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, currency := range money.Currencies() {
+			exponent, err := money.Exponent(currency)
+			if err != nil {
+				return err
+			}
+			if err := tx.Exec(
+				"UPDATE payments SET amount_minor = ROUND(amount * ?) WHERE currency = ?",
+				math.Pow10(exponent), currency,
+			).Error; err != nil {
+				return err
+			}
+		}
+
+		// Dropping the column would lose the amounts of rows in any other currency
+		var unknown []string
+		if err := tx.Model(&Payment{}).
+			Where("currency NOT IN ?", money.Currencies()).
+			Distinct().
+			Pluck("currency", &unknown).Error; err != nil {
+			return err
+		}
+		if len(unknown) > 0 {
+			return fmt.Errorf("cannot convert payment amounts to minor units, unknown currencies: %s", strings.Join(unknown, ", "))
+		}
+
+		return tx.Migrator().DropColumn(&Payment{}, "amount")
+	})
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+	"vinak/pkg/money"
+)
+
+// Overlay is a stream overlay subscribed to the live donation feed. Donations below the
+// minimum for their currency, in minor units, are not shown on it.
+type Overlay struct {
+	ID         uint             `gorm:"primary_key;auto_increment"`
+	Name       string           `gorm:"not null"`
+	Token      string           `gorm:"unique;not null"`
+	MinAmounts map[string]int64 `gorm:"serializer:json;type:jsonb"`
+	Active     bool             `gorm:"not null;default:true"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Accepts reports whether a donation is large enough to be shown on the overlay
+func (o *Overlay) Accepts(amount money.Money) bool {
+	return amount.Amount >= o.MinAmounts[amount.Currency]
+}
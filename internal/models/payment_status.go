@@ -0,0 +1,67 @@
+package models
+
+import "vinak/pkg/constants"
+
+// paymentTransitions lists the statuses a payment may move to from each status.
+// Statuses missing from the map are final.
+var paymentTransitions = map[string][]string{
+	constants.PaymentStatusPending: {
+		constants.PaymentStatusAwaitingConfirmation,
+		constants.PaymentStatusCompleted,
+		constants.PaymentStatusFailed,
+		constants.PaymentStatusExpired,
+	},
+	constants.PaymentStatusAwaitingConfirmation: {
+		constants.PaymentStatusCompleted,
+		constants.PaymentStatusFailed,
+		constants.PaymentStatusExpired,
+	},
+	// Any gateway can confirm a payment after we gave up waiting for it: a late crypto
+	// transfer, or a donor returning from PayPal or Zarinpal past the expiry window whose
+	// order is still captured or verified. The money was received, so the payment
+	// completes rather than staying expired.
+	constants.PaymentStatusExpired: {
+		constants.PaymentStatusCompleted,
+	},
+	constants.PaymentStatusCompleted: {
+		constants.PaymentStatusRefunded,
+		constants.PaymentStatusPartiallyRefunded,
+	},
+	constants.PaymentStatusPartiallyRefunded: {
+		constants.PaymentStatusPartiallyRefunded,
+		constants.PaymentStatusRefunded,
+	},
+}
+
+var paymentStatuses = []string{
+	constants.PaymentStatusPending,
+	constants.PaymentStatusAwaitingConfirmation,
+	constants.PaymentStatusCompleted,
+	constants.PaymentStatusFailed,
+	constants.PaymentStatusExpired,
+	constants.PaymentStatusRefunded,
+	constants.PaymentStatusPartiallyRefunded,
+}
+
+func IsPaymentStatus(status string) bool {
+	for _, known := range paymentStatuses {
+		if known == status {
+			return true
+		}
+	}
+	return false
+}
+
+func CanTransitionPayment(from, to string) bool {
+	for _, allowed := range paymentTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOpenPaymentStatus reports whether the payment is still waiting on the donor or gateway
+func IsOpenPaymentStatus(status string) bool {
+	return status == constants.PaymentStatusPending || status == constants.PaymentStatusAwaitingConfirmation
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"testing"
+	"vinak/pkg/constants"
+)
+
+func TestCanTransitionPayment(t *testing.T) {
+	const (
+		pending           = constants.PaymentStatusPending
+		awaiting          = constants.PaymentStatusAwaitingConfirmation
+		completed         = constants.PaymentStatusCompleted
+		failed            = constants.PaymentStatusFailed
+		expired           = constants.PaymentStatusExpired
+		refunded          = constants.PaymentStatusRefunded
+		partiallyRefunded = constants.PaymentStatusPartiallyRefunded
+	)
+
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{pending, awaiting, true},
+		{pending, completed, true},
+		{pending, failed, true},
+		{pending, expired, true},
+		{pending, refunded, false},
+		{pending, partiallyRefunded, false},
+		{pending, pending, false},
+
+		{awaiting, completed, true},
+		{awaiting, failed, true},
+		{awaiting, expired, true},
+		{awaiting, pending, false},
+		{awaiting, refunded, false},
+
+		{expired, completed, true},
+		{expired, pending, false},
+		{expired, failed, false},
+		{expired, refunded, false},
+
+		{completed, refunded, true},
+		{completed, partiallyRefunded, true},
+		{completed, failed, false},
+		{completed, expired, false},
+		{completed, pending, false},
+
+		{partiallyRefunded, partiallyRefunded, true},
+		{partiallyRefunded, refunded, true},
+		{partiallyRefunded, completed, false},
+
+		{failed, completed, false},
+		{failed, pending, false},
+		{refunded, completed, false},
+		{refunded, partiallyRefunded, false},
+
+		{"unknown", completed, false},
+		{pending, "unknown", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			if got := CanTransitionPayment(tt.from, tt.to); got != tt.want {
+				t.Errorf("CanTransitionPayment(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransitionTargetsAreKnownStatuses(t *testing.T) {
+	known := map[string]bool{
+		constants.PaymentStatusPending:              true,
+		constants.PaymentStatusAwaitingConfirmation: true,
+		constants.PaymentStatusCompleted:            true,
+		constants.PaymentStatusFailed:               true,
+		constants.PaymentStatusExpired:              true,
+		constants.PaymentStatusRefunded:             true,
+		constants.PaymentStatusPartiallyRefunded:    true,
+	}
+
+	for from, targets := range paymentTransitions {
+		if !known[from] {
+			t.Errorf("transitions from unknown status %q", from)
+		}
+		for _, to := range targets {
+			if !known[to] {
+				t.Errorf("transition %q -> unknown status %q", from, to)
+			}
+		}
+	}
+}
+
+func TestIsOpenPaymentStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   bool
+	}{
+		{constants.PaymentStatusPending, true},
+		{constants.PaymentStatusAwaitingConfirmation, true},
+		{constants.PaymentStatusCompleted, false},
+		{constants.PaymentStatusFailed, false},
+		{constants.PaymentStatusExpired, false},
+		{constants.PaymentStatusRefunded, false},
+		{constants.PaymentStatusPartiallyRefunded, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			if got := IsOpenPaymentStatus(tt.status); got != tt.want {
+				t.Errorf("IsOpenPaymentStatus(%q) = %v, want %v", tt.status, got, tt.want)
+			}
+		})
+	}
+}
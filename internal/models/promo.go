@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+	"vinak/pkg/money"
+)
+
+// PromoCode changes the minimum donation for the currencies in MinAmounts, in minor
+// units, and credits donations made with it an extra BonusPercent towards supporter
+// tiers. Zero usage limits and nil dates leave the code unrestricted.
+type PromoCode struct {
+	ID             uint             `gorm:"primary_key;auto_increment"`
+	Code           string           `gorm:"unique;not null"`
+	Description    string           `gorm:"not null;default:''"`
+	MinAmounts     map[string]int64 `gorm:"serializer:json;type:jsonb"`
+	BonusPercent   int              `gorm:"not null;default:0"`
+	MaxUses        int              `gorm:"not null;default:0"`
+	MaxUsesPerUser int              `gorm:"not null;default:0"`
+	StartsAt       *time.Time       `gorm:"default:null"`
+	ExpiresAt      *time.Time       `gorm:"default:null"`
+	Active         bool             `gorm:"not null;default:true"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Available reports whether the code can be redeemed at t, usage limits aside
+func (p *PromoCode) Available(t time.Time) bool {
+	if !p.Active || (p.StartsAt != nil && t.Before(*p.StartsAt)) {
+		return false
+	}
+	return p.ExpiresAt == nil || t.Before(*p.ExpiresAt)
+}
+
+// TierBonus is the extra tier credit the code grants on a donation, in minor units
+func (p *PromoCode) TierBonus(amount money.Money) int64 {
+	return amount.Amount * int64(p.BonusPercent) / 100
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"testing"
+	"time"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
+)
+
+func TestPromoCodeAvailable(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	before := now.Add(-time.Hour)
+	after := now.Add(time.Hour)
+
+	tests := []struct {
+		name  string
+		promo PromoCode
+		want  bool
+	}{
+		{"active without window", PromoCode{Active: true}, true},
+		{"inactive", PromoCode{Active: false}, false},
+		{"inactive within window", PromoCode{Active: false, StartsAt: &before, ExpiresAt: &after}, false},
+		{"within window", PromoCode{Active: true, StartsAt: &before, ExpiresAt: &after}, true},
+		{"not started", PromoCode{Active: true, StartsAt: &after}, false},
+		{"starts now", PromoCode{Active: true, StartsAt: &now}, true},
+		{"expired", PromoCode{Active: true, ExpiresAt: &before}, false},
+		{"expires now", PromoCode{Active: true, ExpiresAt: &now}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.promo.Available(now); got != tt.want {
+				t.Errorf("Available() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromoCodeTierBonus(t *testing.T) {
+	tests := []struct {
+		name         string
+		bonusPercent int
+		amount       money.Money
+		want         int64
+	}{
+		{"no bonus", 0, money.New(1000, constants.CurrencyUSD), 0},
+		{"ten percent", 10, money.New(1000, constants.CurrencyUSD), 100},
+		{"rounds down", 10, money.New(999, constants.CurrencyUSD), 99},
+		{"double", 100, money.New(1250, constants.CurrencyUSD), 1250},
+		{"above double", 250, money.New(400, constants.CurrencyIRT), 1000},
+		{"satoshis", 5, money.New(100000000, constants.CurrencyBTC), 5000000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			promo := PromoCode{BonusPercent: tt.bonusPercent}
+			if got := promo.TierBonus(tt.amount); got != tt.want {
+				t.Errorf("TierBonus(%+v) = %d, want %d", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+	"vinak/pkg/money"
+)
+
+// Refund is money returned on a payment, in the payment's requested currency. Manual refunds
+// were settled outside the gateway and are only recorded here.
+type Refund struct {
+	ID               uint    `gorm:"primary_key;auto_increment"`
+	PaymentID        uint    `gorm:"not null;index"`
+	AmountMinor      int64   `gorm:"not null"`
+	Currency         string  `gorm:"not null"`
+	Status           string  `gorm:"not null"`
+	Reason           string  `gorm:"not null;default:''"`
+	Manual           bool    `gorm:"not null;default:false"`
+	GatewayReference *string `gorm:"index;default:null"`
+	CreatedByID      *uint   `gorm:"default:null"`
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+func (r *Refund) Money() money.Money {
+	return money.New(r.AmountMinor, r.Currency)
+}
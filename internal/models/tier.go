@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+	"vinak/pkg/constants"
+)
+
+// Tier is a reward level donors reach once their donations, less refunds, meet its
+// threshold in any one currency. Thresholds are in minor units and Level orders tiers
+// from lowest to highest.
+type Tier struct {
+	ID         uint             `gorm:"primary_key;auto_increment"`
+	Name       string           `gorm:"not null"`
+	Reward     string           `gorm:"not null;default:''"`
+	Level      int              `gorm:"unique;not null"`
+	Thresholds map[string]int64 `gorm:"serializer:json;type:jsonb"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// ReachedBy reports whether donation totals per currency, in minor units, meet the tier.
+// Meeting the threshold in any one currency is enough, totals in different currencies
+// aren't added up.
+func (t *Tier) ReachedBy(totals map[string]int64) bool {
+	for currency, threshold := range t.Thresholds {
+		if totals[currency] >= threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// TierAward records a donor reaching a tier and whether its reward was delivered. Awards
+// are kept if the donations behind them are later refunded, as the reward may already
+// have been sent.
+type TierAward struct {
+	ID               uint `gorm:"primary_key;auto_increment"`
+	UserID           uint `gorm:"not null;uniqueIndex:idx_tier_awards_user_tier"`
+	TierID           uint `gorm:"not null;uniqueIndex:idx_tier_awards_user_tier"`
+	Tier             Tier
+	FulfilmentStatus string     `gorm:"not null;default:'pending'"`
+	FulfilmentNote   string     `gorm:"not null;default:''"`
+	FulfilledAt      *time.Time `gorm:"default:null"`
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+func IsFulfilmentStatus(status string) bool {
+	switch status {
+	case constants.FulfilmentStatusPending, constants.FulfilmentStatusFulfilled, constants.FulfilmentStatusCancelled:
+		return true
+	}
+	return false
+}
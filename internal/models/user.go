@@ -1,7 +1,10 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
 )
 
 type User struct {
@@ -13,22 +16,92 @@ type User struct {
 	Password          string `gorm:"not null"`
 	EmailVerified     bool   `gorm:"default:false"`
 	VerificationToken string `gorm:"unique"`
+	IsAdmin           bool   `gorm:"default:false"`
+	Privacy           string `gorm:"not null;default:'public'"`
 	CreatedAt         time.Time
 	UpdatedAt         time.Time
 }
 
+func IsPrivacySetting(privacy string) bool {
+	switch privacy {
+	case constants.PrivacyPublic, constants.PrivacyNameOnly, constants.PrivacyAnonymous:
+		return true
+	}
+	return false
+}
+
+// PublicIdentity is how a donor is shown publicly given their privacy setting and
+// whether the donation itself was made anonymously.
+func PublicIdentity(name, instagramID, privacy string, anonymous bool) (string, string) {
+	switch {
+	case anonymous || privacy == constants.PrivacyAnonymous:
+		return constants.AnonymousDonorName, ""
+	case privacy == constants.PrivacyNameOnly:
+		return name, ""
+	default:
+		return name, instagramID
+	}
+}
+
+// CountedPaymentStatuses are the statuses whose amounts, less refunds, count towards
+// donation totals
+var CountedPaymentStatuses = []string{constants.PaymentStatusCompleted, constants.PaymentStatusPartiallyRefunded}
+
 type Payment struct {
-	ID                   uint    `gorm:"primary_key;auto_increment"`
-	UserID               uint    `gorm:"not null"`
-	Amount               float64 `gorm:"not null"`
-	Status               string  `gorm:"not null"`
-	Currency             string  `gorm:"not null"`
-	PayPalOrderID        *string `gorm:"default:null"`
-	NowPaymentsPaymentID *string `gorm:"default:null"`
-	ZarinpalAuthority    *string `gorm:"default:null"`
-	ZarinpalRefID        *string `gorm:"default:null"`
+	ID                   uint       `gorm:"primary_key;auto_increment"`
+	UserID               uint       `gorm:"not null"`
+	AmountMinor          int64      `gorm:"not null;default:0"`
+	ChargedAmountMinor   int64      `gorm:"not null;default:0"`
+	ChargedCurrency      string     `gorm:"not null;default:''"`
+	RefundedAmountMinor  int64      `gorm:"not null;default:0"`
+	Status               string     `gorm:"not null"`
+	Currency             string     `gorm:"not null"`
+	Gateway              string     `gorm:"index:idx_payments_gateway_reference"`
+	GatewayReference     *string    `gorm:"index:idx_payments_gateway_reference;default:null"`
+	GatewayTransactionID *string    `gorm:"default:null"`
+	LastCheckedAt        *time.Time `gorm:"default:null"`
+	Message              string     `gorm:"not null;default:''"`
+	MessageStatus        string     `gorm:"not null;default:''"`
+	MessageFlags         string     `gorm:"not null;default:''"`
+	Anonymous            bool       `gorm:"not null;default:false"`
+	CampaignID           *uint      `gorm:"index;default:null"`
+	PromoCodeID          *uint      `gorm:"index;default:null"`
+	TierBonusMinor       int64      `gorm:"not null;default:0"`
+	RecipientEmail       string     `gorm:"index;not null;default:''"`
+	RecipientInstagramID string     `gorm:"not null;default:''"`
 	CreatedAt            time.Time
 	UpdatedAt            time.Time
+	// CreditedUserID is who the donation counts for on leaderboards and tiers: the donor,
+	// or the recipient of a gift. It is nil while a gift waits for its recipient to sign up.
+	CreditedUserID *uint `gorm:"index;default:null"`
+}
+
+// IsGift reports whether the payment was made in someone else's name
+func (p *Payment) IsGift() bool {
+	return p.RecipientEmail != "" || p.RecipientInstagramID != ""
+}
+
+// Money is the amount the donor asked to give
+func (p *Payment) Money() money.Money {
+	return money.New(p.AmountMinor, p.Currency)
+}
+
+// ChargedMoney is the amount the gateway was asked to collect
+func (p *Payment) ChargedMoney() money.Money {
+	return money.New(p.ChargedAmountMinor, p.ChargedCurrency)
+}
+
+// ApprovedMessage returns the donor's message if a moderator (or the filter) let it through
+func (p *Payment) ApprovedMessage() string {
+	if p.MessageStatus != constants.MessageStatusApproved {
+		return ""
+	}
+	return p.Message
+}
+
+// RefundedMoney is how much of the requested amount has been returned to the donor
+func (p *Payment) RefundedMoney() money.Money {
+	return money.New(p.RefundedAmountMinor, p.Currency)
 }
 
 type PaymentLog struct {
@@ -39,11 +112,16 @@ type PaymentLog struct {
 	CreatedAt time.Time
 }
 
+// TopUserResponse is one ranked entry of a leaderboard. UserID is never exposed since
+// anonymous entries must not be traceable to the donor.
 type TopUserResponse struct {
-	UserID       uint    `json:"user_id"`
-	Name         string  `json:"name"`
-	InstagramID  string  `json:"instagram_id"`
-	TotalAmount  float64 `json:"total_amount"`
-	Currency     string  `json:"currency"`
-	PaymentCount int     `json:"payment_count"`
+	Rank           int         `json:"rank"`
+	UserID         uint        `json:"-"`
+	Name           string      `json:"name"`
+	InstagramID    string      `json:"instagram_id"`
+	TotalAmount    json.Number `json:"total_amount"`
+	Currency       string      `json:"currency"`
+	PaymentCount   int         `json:"payment_count"`
+	LastDonationAt time.Time   `json:"last_donation_at"`
+	Message        string      `json:"message,omitempty"`
 }
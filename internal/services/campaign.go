@@ -0,0 +1,105 @@
+package services
+
+import (
+	stderrors "errors"
+	"time"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCampaignNotFound  = stderrors.New("campaign not found")
+	ErrCampaignNotActive = stderrors.New("campaign is not accepting donations")
+)
+
+// CampaignProgress is what a campaign raised in one currency, less refunds, against its
+// target there. Target is zero for currencies the campaign has no target in.
+type CampaignProgress struct {
+	Raised money.Money
+	Target money.Money
+}
+
+type CampaignService struct {
+	db *gorm.DB
+}
+
+func NewCampaignService(db *gorm.DB) *CampaignService {
+	return &CampaignService{
+		db: db,
+	}
+}
+
+// Public finds a campaign by slug unless it is still a draft
+func (s *CampaignService) Public(slug string) (*models.Campaign, error) {
+	var campaign models.Campaign
+	err := s.db.Where("slug = ? AND status <> ?", slug, constants.CampaignStatusDraft).First(&campaign).Error
+	if stderrors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCampaignNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+// ForDonation finds the campaign a new payment is made towards
+func (s *CampaignService) ForDonation(slug string) (*models.Campaign, error) {
+	campaign, err := s.Public(slug)
+	if err != nil {
+		return nil, err
+	}
+	if !campaign.AcceptsDonations(time.Now()) {
+		return nil, ErrCampaignNotActive
+	}
+	return campaign, nil
+}
+
+// Progress returns the campaign's progress per currency, for every currency it has a
+// target in or received donations in, and how many supporters it is credited to.
+func (s *CampaignService) Progress(campaign *models.Campaign) ([]CampaignProgress, int64, error) {
+	var rows []struct {
+		Currency string
+		Raised   int64
+	}
+	if err := s.db.Model(&models.Payment{}).
+		Select("currency, SUM(amount_minor - refunded_amount_minor) AS raised").
+		Where("campaign_id = ? AND status IN ?", campaign.ID, models.CountedPaymentStatuses).
+		Group("currency").
+		Scan(&rows).Error; err != nil {
+		return nil, 0, err
+	}
+
+	raised := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		raised[row.Currency] = row.Raised
+	}
+
+	var progress []CampaignProgress
+	for _, currency := range money.Currencies() {
+		target, hasTarget := campaign.Targets[currency]
+		amount, hasDonations := raised[currency]
+		if !hasTarget && !hasDonations {
+			continue
+		}
+		progress = append(progress, CampaignProgress{
+			Raised: money.New(amount, currency),
+			Target: money.New(target, currency),
+		})
+	}
+
+	// Supporters are the users donations are credited to. An unclaimed gift has no user
+	// yet, so its recipient is counted by the email it was sent to, which is the account
+	// it will be credited to once claimed.
+	var supporters int64
+	if err := s.db.Model(&models.Payment{}).
+		Select("COUNT(DISTINCT COALESCE(CAST(credited_user_id AS TEXT), 'email:' || recipient_email))").
+		Where("campaign_id = ? AND status IN ?", campaign.ID, models.CountedPaymentStatuses).
+		Scan(&supporters).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return progress, supporters, nil
+}
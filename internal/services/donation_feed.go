@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const donationFeedChannel = "donation_feed"
+
+// Donation is a completed payment as shown on stream overlays.
+type Donation struct {
+	PaymentID   uint        `json:"payment_id"`
+	Name        string      `json:"name"`
+	InstagramID string      `json:"instagram_id"`
+	Amount      json.Number `json:"amount"`
+	Currency    string      `json:"currency"`
+	Message     string      `json:"message,omitempty"`
+	At          time.Time   `json:"at"`
+	// AmountMinor is kept for filtering against overlay minimums
+	AmountMinor int64 `json:"amount_minor"`
+}
+
+// DonationFeedService broadcasts completed donations to every connected overlay through
+// Redis pub/sub.
+type DonationFeedService struct {
+	redis *redis.Client
+}
+
+func NewDonationFeedService(redis *redis.Client) *DonationFeedService {
+	return &DonationFeedService{
+		redis: redis,
+	}
+}
+
+func (s *DonationFeedService) Publish(donation Donation) error {
+	return publish(s.redis, donationFeedChannel, donation)
+}
+
+// Subscribe streams donations until ctx is done
+func (s *DonationFeedService) Subscribe(ctx context.Context) (<-chan Donation, error) {
+	return subscribe[Donation](ctx, s.redis, donationFeedChannel)
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/storage"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrDownloadNotAllowed   = stderrors.New("user has no completed donation")
+	ErrInvalidDownloadLink  = stderrors.New("download link is invalid or expired")
+	ErrDownloadLimitReached = stderrors.New("download limit reached")
+	ErrAssetNotFound        = stderrors.New("asset not found")
+)
+
+// DownloadLink is a signed URL for one user to download one asset until it expires
+type DownloadLink struct {
+	URL       string
+	ExpiresAt time.Time
+}
+
+// DownloadService hands album assets to supporters. Links are signed with an HMAC over
+// the asset, the user and the expiry, so they can't be shared past their expiry or
+// altered to fetch another asset. Whether the user may download is checked again each
+// time a link is used, so refunding a user's donations revokes links already issued.
+type DownloadService struct {
+	db      *gorm.DB
+	store   storage.Store
+	baseURL string
+	secret  []byte
+	ttl     time.Duration
+	// limit caps downloads per user and asset, 0 for no limit
+	limit int
+}
+
+func NewDownloadService(db *gorm.DB, store storage.Store, baseURL, secret string, ttl time.Duration, limit int) *DownloadService {
+	return &DownloadService{
+		db:      db,
+		store:   store,
+		baseURL: baseURL,
+		secret:  []byte(secret),
+		ttl:     ttl,
+		limit:   limit,
+	}
+}
+
+// Limit returns how often a user can download each asset, 0 if there is no limit
+func (s *DownloadService) Limit() int {
+	return s.limit
+}
+
+// Eligible reports whether a user is credited with a completed donation that wasn't fully
+// refunded. Gifts count for their recipient, not the donor.
+func (s *DownloadService) Eligible(userID uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.Payment{}).
+		Where("credited_user_id = ? AND status IN ?", userID, models.CountedPaymentStatuses).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Assets returns every downloadable asset in display order
+func (s *DownloadService) Assets() ([]models.Asset, error) {
+	var assets []models.Asset
+	err := s.db.Order("position, id").Find(&assets).Error
+	return assets, err
+}
+
+// Downloads returns a user's download counts keyed by asset ID
+func (s *DownloadService) Downloads(userID uint) (map[uint]models.AssetDownload, error) {
+	var downloads []models.AssetDownload
+	if err := s.db.Where("user_id = ?", userID).Find(&downloads).Error; err != nil {
+		return nil, err
+	}
+
+	byAsset := make(map[uint]models.AssetDownload, len(downloads))
+	for _, download := range downloads {
+		byAsset[download.AssetID] = download
+	}
+	return byAsset, nil
+}
+
+// Sign returns a link for userID to download assetID
+func (s *DownloadService) Sign(assetID, userID uint) DownloadLink {
+	expiresAt := time.Now().Add(s.ttl).Truncate(time.Second)
+
+	query := url.Values{}
+	query.Set(constants.QueryDownloadUser, strconv.FormatUint(uint64(userID), 10))
+	query.Set(constants.QueryDownloadExpires, strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set(constants.QueryDownloadSignature, s.signature(assetID, userID, expiresAt.Unix()))
+
+	return DownloadLink{
+		URL:       fmt.Sprintf("%s/api/downloads/%d?%s", s.baseURL, assetID, query.Encode()),
+		ExpiresAt: expiresAt,
+	}
+}
+
+// Verify checks that a link's signature matches and it hasn't expired
+func (s *DownloadService) Verify(assetID, userID uint, expires int64, signature string) error {
+	expected := s.signature(assetID, userID, expires)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidDownloadLink
+	}
+	if time.Now().Unix() > expires {
+		return ErrInvalidDownloadLink
+	}
+	return nil
+}
+
+func (s *DownloadService) signature(assetID, userID uint, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%d:%d:%d", assetID, userID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Download checks that a user may still download an asset, counts the download and
+// returns where to deliver it from. Callers verify the link first.
+func (s *DownloadService) Download(assetID, userID uint) (*models.Asset, storage.Location, error) {
+	eligible, err := s.Eligible(userID)
+	if err != nil {
+		return nil, storage.Location{}, err
+	}
+	if !eligible {
+		return nil, storage.Location{}, ErrDownloadNotAllowed
+	}
+
+	var asset models.Asset
+	err = s.db.First(&asset, assetID).Error
+	if stderrors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, storage.Location{}, ErrAssetNotFound
+	}
+	if err != nil {
+		return nil, storage.Location{}, err
+	}
+
+	location, err := s.store.Locate(asset.Key, asset.FileName)
+	if stderrors.Is(err, storage.ErrNotFound) {
+		return nil, storage.Location{}, ErrAssetNotFound
+	}
+	if err != nil {
+		return nil, storage.Location{}, err
+	}
+
+	if err := s.record(assetID, userID); err != nil {
+		return nil, storage.Location{}, err
+	}
+	return &asset, location, nil
+}
+
+// record counts a download. With a limit the count is only raised while below it, so
+// concurrent requests can't go over.
+func (s *DownloadService) record(assetID, userID uint) error {
+	now := time.Now()
+	conflict := clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "asset_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"count":              gorm.Expr("asset_downloads.count + 1"),
+			"last_downloaded_at": now,
+		}),
+	}
+	if s.limit > 0 {
+		conflict.Where = clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "asset_downloads.count < ?", Vars: []interface{}{s.limit}},
+		}}
+	}
+
+	result := s.db.Clauses(conflict).Create(&models.AssetDownload{
+		UserID:           userID,
+		AssetID:          assetID,
+		Count:            1,
+		LastDownloadedAt: now,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDownloadLimitReached
+	}
+	return nil
+}
+
+// Store returns the store assets are kept in
+func (s *DownloadService) Store() storage.Store {
+	return s.store
+}
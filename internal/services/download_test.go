@@ -0,0 +1,221 @@
+package services
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
+	"vinak/pkg/payment"
+	"vinak/pkg/storage"
+
+	"gorm.io/gorm"
+)
+
+// parseDownloadLink reads the user, expiry and signature back out of a signed link
+func parseDownloadLink(t *testing.T, link DownloadLink) (uint, int64, string) {
+	t.Helper()
+
+	parsed, err := url.Parse(link.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%q) error = %v", link.URL, err)
+	}
+	query := parsed.Query()
+
+	userID, err := strconv.ParseUint(query.Get(constants.QueryDownloadUser), 10, 64)
+	if err != nil {
+		t.Fatalf("link %q has no user: %v", link.URL, err)
+	}
+	expires, err := strconv.ParseInt(query.Get(constants.QueryDownloadExpires), 10, 64)
+	if err != nil {
+		t.Fatalf("link %q has no expiry: %v", link.URL, err)
+	}
+	return uint(userID), expires, query.Get(constants.QueryDownloadSignature)
+}
+
+func TestDownloadLinkRoundTrip(t *testing.T) {
+	service := NewDownloadService(nil, nil, "https://example.com", "secret", time.Hour, 0)
+	link := service.Sign(7, 42)
+
+	parsed, err := url.Parse(link.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%q) error = %v", link.URL, err)
+	}
+	if got, want := parsed.Path, "/api/downloads/7"; got != want {
+		t.Errorf("link path = %q, want %q", got, want)
+	}
+
+	userID, expires, signature := parseDownloadLink(t, link)
+	altered := []byte(signature)
+	altered[len(altered)-1] ^= 1
+	if userID != 42 {
+		t.Errorf("link user = %d, want 42", userID)
+	}
+	if expires != link.ExpiresAt.Unix() {
+		t.Errorf("link expiry = %d, want %d", expires, link.ExpiresAt.Unix())
+	}
+
+	tests := []struct {
+		name      string
+		service   *DownloadService
+		assetID   uint
+		userID    uint
+		expires   int64
+		signature string
+		wantErr   bool
+	}{
+		{"valid", service, 7, userID, expires, signature, false},
+		{"other asset", service, 8, userID, expires, signature, true},
+		{"other user", service, 7, userID + 1, expires, signature, true},
+		{"extended expiry", service, 7, userID, expires + 3600, signature, true},
+		{"altered signature", service, 7, userID, expires, string(altered), true},
+		{"empty signature", service, 7, userID, expires, "", true},
+		{"other secret", NewDownloadService(nil, nil, "https://example.com", "other", time.Hour, 0), 7, userID, expires, signature, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.service.Verify(tt.assetID, tt.userID, tt.expires, tt.signature)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidDownloadLink) {
+					t.Errorf("Verify() error = %v, want %v", err, ErrInvalidDownloadLink)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestDownloadLinkExpires(t *testing.T) {
+	service := NewDownloadService(nil, nil, "https://example.com", "secret", -time.Minute, 0)
+	link := service.Sign(7, 42)
+
+	userID, expires, signature := parseDownloadLink(t, link)
+	altered := []byte(signature)
+	altered[len(altered)-1] ^= 1
+	if err := service.Verify(7, userID, expires, signature); !errors.Is(err, ErrInvalidDownloadLink) {
+		t.Errorf("Verify() of an expired link error = %v, want %v", err, ErrInvalidDownloadLink)
+	}
+}
+
+func newTestDownloadService(t *testing.T, db *gorm.DB, limit int) (*DownloadService, *models.Asset) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "album.zip"), []byte("album"), 0o600); err != nil {
+		t.Fatalf("failed to write asset: %v", err)
+	}
+
+	asset := models.Asset{Title: "Album", FileName: "album.zip", Key: "album.zip"}
+	if err := db.Create(&asset).Error; err != nil {
+		t.Fatalf("failed to create asset: %v", err)
+	}
+
+	return NewDownloadService(db, storage.NewLocalStore(dir), "https://example.com", "secret", time.Hour, limit), &asset
+}
+
+func TestDownloadRevokedByRefund(t *testing.T) {
+	db := newTestDB(t)
+	service, asset := newTestDownloadService(t, db, 0)
+	payments := NewPaymentService(db, payment.NewRegistry())
+	user := createTestUser(t, db, "donor")
+
+	if _, _, err := service.Download(asset.ID, user.ID); !errors.Is(err, ErrDownloadNotAllowed) {
+		t.Fatalf("Download() without a donation error = %v, want %v", err, ErrDownloadNotAllowed)
+	}
+
+	record := createTestPayment(t, db, models.Payment{
+		UserID:         user.ID,
+		CreditedUserID: &user.ID,
+		AmountMinor:    1000,
+		Currency:       constants.CurrencyUSD,
+		Status:         constants.PaymentStatusCompleted,
+		Gateway:        constants.PaymentGatewayPayPal,
+	})
+
+	_, location, err := service.Download(asset.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if filepath.Base(location.Path) != "album.zip" {
+		t.Errorf("Download() location = %+v, want album.zip", location)
+	}
+
+	// A partial refund leaves the donation counted
+	half := money.New(500, constants.CurrencyUSD)
+	if _, _, err := payments.Refund(RefundRequest{PaymentID: record.ID, Amount: &half, Manual: true}); err != nil {
+		t.Fatalf("Refund() error = %v", err)
+	}
+	if _, _, err := service.Download(asset.ID, user.ID); err != nil {
+		t.Fatalf("Download() after a partial refund error = %v", err)
+	}
+
+	if _, _, err := payments.Refund(RefundRequest{PaymentID: record.ID, Manual: true}); err != nil {
+		t.Fatalf("Refund() error = %v", err)
+	}
+	if _, _, err := service.Download(asset.ID, user.ID); !errors.Is(err, ErrDownloadNotAllowed) {
+		t.Errorf("Download() after a full refund error = %v, want %v", err, ErrDownloadNotAllowed)
+	}
+}
+
+func TestDownloadLimit(t *testing.T) {
+	db := newTestDB(t)
+	service, asset := newTestDownloadService(t, db, 2)
+	user := createTestUser(t, db, "donor")
+	createTestPayment(t, db, models.Payment{
+		UserID:         user.ID,
+		CreditedUserID: &user.ID,
+		AmountMinor:    1000,
+		Currency:       constants.CurrencyUSD,
+		Status:         constants.PaymentStatusCompleted,
+		Gateway:        constants.PaymentGatewayPayPal,
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := service.Download(asset.ID, user.ID); err != nil {
+			t.Fatalf("Download() %d error = %v", i+1, err)
+		}
+	}
+	if _, _, err := service.Download(asset.ID, user.ID); !errors.Is(err, ErrDownloadLimitReached) {
+		t.Errorf("Download() past the limit error = %v, want %v", err, ErrDownloadLimitReached)
+	}
+
+	downloads, err := service.Downloads(user.ID)
+	if err != nil {
+		t.Fatalf("Downloads() error = %v", err)
+	}
+	if got := downloads[asset.ID].Count; got != 2 {
+		t.Errorf("download count = %d, want 2", got)
+	}
+}
+
+func TestDownloadGiftGoesToRecipient(t *testing.T) {
+	db := newTestDB(t)
+	service, asset := newTestDownloadService(t, db, 0)
+	donor := createTestUser(t, db, "donor")
+	recipient := createTestUser(t, db, "recipient")
+	createTestPayment(t, db, models.Payment{
+		UserID:         donor.ID,
+		CreditedUserID: &recipient.ID,
+		RecipientEmail: recipient.Email,
+		AmountMinor:    1000,
+		Currency:       constants.CurrencyUSD,
+		Status:         constants.PaymentStatusCompleted,
+		Gateway:        constants.PaymentGatewayPayPal,
+	})
+
+	if _, _, err := service.Download(asset.ID, recipient.ID); err != nil {
+		t.Errorf("Download() by the recipient error = %v", err)
+	}
+	if _, _, err := service.Download(asset.ID, donor.ID); !errors.Is(err, ErrDownloadNotAllowed) {
+		t.Errorf("Download() by the donor error = %v, want %v", err, ErrDownloadNotAllowed)
+	}
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	stderrors "errors"
+	"log"
+	"strings"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/email"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrRecipientRequired = stderrors.New("recipient without an account needs an email")
+	ErrGiftToSelf        = stderrors.New("donor and recipient are the same user")
+)
+
+// GiftRecipient is who a gift is made out to, as given by the donor
+type GiftRecipient struct {
+	Email       string
+	InstagramID string
+}
+
+// GiftService credits donations made in someone else's name to that person. Gifts to
+// someone without an account wait until they sign up with the email they were sent to.
+type GiftService struct {
+	db             *gorm.DB
+	paymentService *PaymentService
+	emailService   *email.EmailService
+	tierService    *TierService
+	leaderboard    *LeaderboardService
+	// siteURL is linked from gift emails
+	siteURL string
+}
+
+func NewGiftService(db *gorm.DB, paymentService *PaymentService, emailService *email.EmailService, tierService *TierService, leaderboard *LeaderboardService, siteURL string) *GiftService {
+	return &GiftService{
+		db:             db,
+		paymentService: paymentService,
+		emailService:   emailService,
+		tierService:    tierService,
+		leaderboard:    leaderboard,
+		siteURL:        siteURL,
+	}
+}
+
+// NormalizeRecipient trims the recipient and lowercases the email and Instagram handle,
+// dropping a leading @ from the handle
+func NormalizeRecipient(recipient GiftRecipient) GiftRecipient {
+	return GiftRecipient{
+		Email:       strings.ToLower(strings.TrimSpace(recipient.Email)),
+		InstagramID: strings.ToLower(strings.TrimPrefix(strings.TrimSpace(recipient.InstagramID), "@")),
+	}
+}
+
+// Resolve finds the account a gift from donor goes to, nil if the recipient has to sign
+// up first. The email is matched before the Instagram handle.
+func (s *GiftService) Resolve(donor *models.User, recipient GiftRecipient) (*models.User, error) {
+	var users []models.User
+	if recipient.Email != "" {
+		if err := s.db.Where("LOWER(email) = ?", recipient.Email).Limit(1).Find(&users).Error; err != nil {
+			return nil, err
+		}
+	}
+	if len(users) == 0 && recipient.InstagramID != "" {
+		if err := s.db.Where("LOWER(instagram_id) = ?", recipient.InstagramID).Limit(1).Find(&users).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if len(users) == 0 {
+		// Unclaimed gifts are claimed by email, so without one the credit would be lost
+		if recipient.Email == "" {
+			return nil, ErrRecipientRequired
+		}
+		return nil, nil
+	}
+	if users[0].ID == donor.ID {
+		return nil, ErrGiftToSelf
+	}
+	return &users[0], nil
+}
+
+// HandleTransition tells the recipient of a completed gift about it. The email is sent in
+// the background.
+func (s *GiftService) HandleTransition(transition Transition) {
+	record := transition.Payment
+	if transition.To != constants.PaymentStatusCompleted || !record.IsGift() {
+		return
+	}
+
+	to := record.RecipientEmail
+	if record.CreditedUserID != nil {
+		var recipient models.User
+		if err := s.db.First(&recipient, *record.CreditedUserID).Error; err != nil {
+			log.Printf("Failed to find the recipient of gift %d: %v", record.ID, err)
+			return
+		}
+		to = recipient.Email
+	}
+	if to == "" {
+		return
+	}
+
+	var donor models.User
+	if err := s.db.First(&donor, record.UserID).Error; err != nil {
+		log.Printf("Failed to find the donor of gift %d: %v", record.ID, err)
+		return
+	}
+	donorName, _ := models.PublicIdentity(donor.Name, donor.InstagramID, donor.Privacy, record.Anonymous)
+
+	data := email.GiftEmailData{
+		DonorName: donorName,
+		Amount:    record.Money().Format(),
+		Message:   record.ApprovedMessage(),
+		Claimed:   record.CreditedUserID != nil,
+		URL:       s.siteURL,
+	}
+	// Hooks run on the request or worker that settled the payment, so a slow SMTP
+	// server mustn't hold it up
+	go func(paymentID uint) {
+		if err := s.emailService.SendGiftNotification(to, data); err != nil {
+			log.Printf("Failed to send gift email for payment %d: %v", paymentID, err)
+		}
+	}(record.ID)
+}
+
+// Claim credits a newly signed up user with the gifts sent to their email, and returns
+// how many there were
+func (s *GiftService) Claim(user *models.User) (int, error) {
+	var claimed []models.Payment
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&claimed).
+			Clauses(clause.Returning{}).
+			Where("credited_user_id IS NULL AND recipient_email = ?", strings.ToLower(user.Email)).
+			Update("credited_user_id", user.ID).Error; err != nil {
+			return err
+		}
+
+		for _, record := range claimed {
+			if err := s.paymentService.createLog(tx, record.ID, constants.PaymentEventGiftClaimed, map[string]interface{}{
+				"user_id": user.ID,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || len(claimed) == 0 {
+		return 0, err
+	}
+
+	if err := s.tierService.Assign(user.ID); err != nil {
+		log.Printf("Failed to assign tiers to user %d: %v", user.ID, err)
+	}
+	s.leaderboard.HandleClaim(claimed)
+
+	return len(claimed), nil
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used with a different request")
+	ErrRequestInProgress    = errors.New("a request with this idempotency key is still in progress")
+)
+
+type IdempotencyService struct {
+	redis *redis.Client
+	// ttl is how long a completed response is replayed
+	ttl time.Duration
+	// lockTTL is how long a key stays claimed by a request that hasn't finished, so one
+	// that crashed doesn't block retries until ttl runs out
+	lockTTL time.Duration
+}
+
+func NewIdempotencyService(redis *redis.Client, ttl, lockTTL time.Duration) *IdempotencyService {
+	return &IdempotencyService{
+		redis:   redis,
+		ttl:     ttl,
+		lockTTL: lockTTL,
+	}
+}
+
+// StoredResponse is what gets replayed for a retried request. A zero StatusCode marks a
+// request that is still being processed.
+type StoredResponse struct {
+	RequestHash string `json:"request_hash"`
+	StatusCode  int    `json:"status_code"`
+	Body        []byte `json:"body"`
+}
+
+// Begin claims key for a request. It returns the stored response if the request was
+// already completed, or nil if the caller should process it and call Complete or Release.
+func (s *IdempotencyService) Begin(scope, key, requestHash string) (*StoredResponse, error) {
+	ctx := context.Background()
+
+	pending, err := json.Marshal(StoredResponse{RequestHash: requestHash})
+	if err != nil {
+		return nil, err
+	}
+
+	claimed, err := s.redis.SetNX(ctx, s.redisKey(scope, key), pending, s.lockTTL).Result()
+	if err != nil {
+		return nil, err
+	}
+	if claimed {
+		return nil, nil
+	}
+
+	value, err := s.redis.Get(ctx, s.redisKey(scope, key)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			// Expired between the two calls, try again
+			return s.Begin(scope, key, requestHash)
+		}
+		return nil, err
+	}
+
+	var stored StoredResponse
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return nil, err
+	}
+
+	if stored.RequestHash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if stored.StatusCode == 0 {
+		return nil, ErrRequestInProgress
+	}
+
+	return &stored, nil
+}
+
+func (s *IdempotencyService) Complete(scope, key, requestHash string, statusCode int, body []byte) error {
+	value, err := json.Marshal(StoredResponse{
+		RequestHash: requestHash,
+		StatusCode:  statusCode,
+		Body:        body,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Set(context.Background(), s.redisKey(scope, key), value, s.ttl).Err()
+}
+
+// Release forgets the key so the client can retry, e.g. after a server error
+func (s *IdempotencyService) Release(scope, key string) error {
+	return s.redis.Del(context.Background(), s.redisKey(scope, key)).Err()
+}
+
+func (s *IdempotencyService) redisKey(scope, key string) string {
+	return "idempotency:" + scope + ":" + key
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	_, client := newTestRedis(t)
+	service := NewIdempotencyService(client, time.Hour, time.Minute)
+
+	stored, err := service.Begin("scope", "key", "hash")
+	if err != nil || stored != nil {
+		t.Fatalf("Begin() = %+v, %v, want the key claimed", stored, err)
+	}
+
+	if _, err := service.Begin("scope", "key", "hash"); !errors.Is(err, ErrRequestInProgress) {
+		t.Errorf("Begin() while in progress error = %v, want %v", err, ErrRequestInProgress)
+	}
+	if _, err := service.Begin("scope", "key", "other"); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("Begin() with another request error = %v, want %v", err, ErrIdempotencyKeyReused)
+	}
+
+	if err := service.Complete("scope", "key", "hash", http.StatusOK, []byte(`{"payment_id":1}`)); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+
+	stored, err = service.Begin("scope", "key", "hash")
+	if err != nil {
+		t.Fatalf("Begin() after Complete() error = %v", err)
+	}
+	if stored == nil || stored.StatusCode != http.StatusOK || string(stored.Body) != `{"payment_id":1}` {
+		t.Errorf("Begin() after Complete() = %+v, want the stored response", stored)
+	}
+	if _, err := service.Begin("scope", "key", "other"); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("Begin() with another request error = %v, want %v", err, ErrIdempotencyKeyReused)
+	}
+
+	// Keys are scoped to the caller
+	if stored, err := service.Begin("other-scope", "key", "hash"); err != nil || stored != nil {
+		t.Errorf("Begin() in another scope = %+v, %v, want the key claimed", stored, err)
+	}
+}
+
+func TestIdempotencyRelease(t *testing.T) {
+	_, client := newTestRedis(t)
+	service := NewIdempotencyService(client, time.Hour, time.Minute)
+
+	if _, err := service.Begin("scope", "key", "hash"); err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if err := service.Release("scope", "key"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if stored, err := service.Begin("scope", "key", "other"); err != nil || stored != nil {
+		t.Errorf("Begin() after Release() = %+v, %v, want the key claimed", stored, err)
+	}
+}
+
+func TestIdempotencyLockExpires(t *testing.T) {
+	server, client := newTestRedis(t)
+	service := NewIdempotencyService(client, time.Hour, time.Minute)
+
+	if _, err := service.Begin("scope", "key", "hash"); err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+
+	// The request that claimed the key died without completing it
+	server.FastForward(time.Minute + time.Second)
+	if stored, err := service.Begin("scope", "key", "hash"); err != nil || stored != nil {
+		t.Fatalf("Begin() after the lock expired = %+v, %v, want the key claimed", stored, err)
+	}
+
+	// Completed responses are kept for the full TTL
+	if err := service.Complete("scope", "key", "hash", http.StatusOK, []byte(`{}`)); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	server.FastForward(30 * time.Minute)
+	if stored, err := service.Begin("scope", "key", "hash"); err != nil || stored == nil {
+		t.Errorf("Begin() within the TTL = %+v, %v, want the stored response", stored, err)
+	}
+	server.FastForward(31 * time.Minute)
+	if stored, err := service.Begin("scope", "key", "hash"); err != nil || stored != nil {
+		t.Errorf("Begin() after the TTL = %+v, %v, want the key claimed", stored, err)
+	}
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	stderrors "errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// LeaderboardQuery selects one ranked list. From is inclusive and To exclusive, nil
+// leaves that side of the period open. Combined ranks donations in every currency
+// together in the reference currency, ignoring Currency. A CampaignID ranks only the
+// donations made towards that campaign. Period names the list so the all, month and
+// week lists can be served from Redis; custom periods and campaigns always read the
+// database.
+type LeaderboardQuery struct {
+	Currency   string
+	Combined   bool
+	CampaignID *uint
+	Period     string
+	From       *time.Time
+	To         *time.Time
+	Limit      int
+	Offset     int
+}
+
+type LeaderboardService struct {
+	db                *gorm.DB
+	redis             *redis.Client
+	referenceCurrency string
+}
+
+func NewLeaderboardService(db *gorm.DB, redis *redis.Client, referenceCurrency string) *LeaderboardService {
+	return &LeaderboardService{
+		db:                db,
+		redis:             redis,
+		referenceCurrency: referenceCurrency,
+	}
+}
+
+// LeaderboardPeriodBounds returns the month or week containing t. Weeks start on Monday
+// and months on the 1st, both in UTC.
+func LeaderboardPeriodBounds(period string, t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	if period == constants.LeaderboardPeriodMonth {
+		from := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(0, 1, 0)
+	}
+
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	from := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	return from, from.AddDate(0, 0, 7)
+}
+
+// donationRateJoin attaches the exchange rate in effect when each payment was made: the
+// latest one fetched before it, or for payments older than any stored rate the earliest
+// one. Payments in the reference currency convert at 1.
+const donationRateJoin = `CROSS JOIN LATERAL (SELECT CASE WHEN payments.currency = ? THEN 1 ELSE COALESCE(
+	(SELECT rate FROM exchange_rates WHERE currency = payments.currency AND reference_currency = ?
+		AND fetched_at <= payments.created_at ORDER BY fetched_at DESC LIMIT 1),
+	(SELECT rate FROM exchange_rates WHERE currency = payments.currency AND reference_currency = ?
+		ORDER BY fetched_at LIMIT 1)
+) END AS rate) AS donation_rates`
+
+type leaderboardRow struct {
+	Rank           int
+	UserID         uint
+	Name           string
+	InstagramID    string
+	Privacy        string
+	Anonymous      bool
+	TotalAmount    int64
+	PaymentCount   int
+	LastDonationAt time.Time
+}
+
+// TopUsers ranks donors by what they gave in a currency, less refunds. Anonymous
+// donations are ranked apart from the donor's named ones so they can't be linked back
+// to them, and every entry is shown according to the donor's privacy setting. Combined
+// totals convert each donation at the rate in effect when it was made.
+func (s *LeaderboardService) TopUsers(query LeaderboardQuery) ([]models.TopUserResponse, error) {
+	if key, ok := s.cacheKey(query); ok {
+		entries, err := s.cachedTopUsers(key, query)
+		if err == nil {
+			return entries, nil
+		}
+		if !stderrors.Is(err, errLeaderboardNotBuilt) {
+			log.Printf("Failed to read leaderboard %s from Redis: %v", key, err)
+		}
+	}
+
+	db, err := s.rankQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []leaderboardRow
+	if err := db.Order("rank, last_donation_at").
+		Limit(query.Limit).
+		Offset(query.Offset).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	return s.entries(rows, s.currency(query))
+}
+
+// currency is what the query's totals are in
+func (s *LeaderboardService) currency(query LeaderboardQuery) string {
+	if query.Combined {
+		return s.referenceCurrency
+	}
+	return query.Currency
+}
+
+// rankQuery totals and ranks the counted payments of each donor matching query
+func (s *LeaderboardService) rankQuery(query LeaderboardQuery) (*gorm.DB, error) {
+	currency := s.currency(query)
+	total := "SUM(payments.amount_minor - payments.refunded_amount_minor)"
+
+	db := s.db.Model(&models.Payment{}).
+		Joins("JOIN users ON users.id = payments.credited_user_id").
+		Where("payments.status IN ?", models.CountedPaymentStatuses)
+	if query.Combined {
+		var err error
+		if total, err = convertedTotalSQL(currency); err != nil {
+			return nil, err
+		}
+		// Donations in a currency there's no rate for yet can't be ranked
+		db = db.Joins(donationRateJoin, currency, currency, currency).
+			Where("donation_rates.rate IS NOT NULL")
+	} else {
+		db = db.Where("payments.currency = ?", currency)
+	}
+	if query.CampaignID != nil {
+		db = db.Where("payments.campaign_id = ?", *query.CampaignID)
+	}
+	if query.From != nil {
+		db = db.Where("payments.created_at >= ?", *query.From)
+	}
+	if query.To != nil {
+		db = db.Where("payments.created_at < ?", *query.To)
+	}
+
+	return db.Select("RANK() OVER (ORDER BY " + total + " DESC) AS rank, " +
+		"users.id AS user_id, users.name, users.instagram_id, users.privacy, payments.anonymous, " +
+		total + " AS total_amount, COUNT(*) AS payment_count, MAX(payments.created_at) AS last_donation_at").
+		Group("users.id, users.name, users.instagram_id, users.privacy, payments.anonymous"), nil
+}
+
+// entries renders ranked rows as public leaderboard entries
+func (s *LeaderboardService) entries(rows []leaderboardRow, currency string) ([]models.TopUserResponse, error) {
+	userIDs := make([]uint, len(rows))
+	for i, row := range rows {
+		userIDs[i] = row.UserID
+	}
+
+	messages, err := s.latestMessages(userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]models.TopUserResponse, len(rows))
+	for i, row := range rows {
+		name, instagramID := models.PublicIdentity(row.Name, row.InstagramID, row.Privacy, row.Anonymous)
+		entries[i] = models.TopUserResponse{
+			Rank:           row.Rank,
+			UserID:         row.UserID,
+			Name:           name,
+			InstagramID:    instagramID,
+			TotalAmount:    money.New(row.TotalAmount, currency).Decimal(),
+			Currency:       currency,
+			PaymentCount:   row.PaymentCount,
+			LastDonationAt: row.LastDonationAt,
+			Message:        messages[messageKey{row.UserID, row.Anonymous}],
+		}
+	}
+
+	return entries, nil
+}
+
+// convertedTotalSQL sums the net payments converted to minor units of the reference
+// currency at their donation rate, rounded to a whole minor unit
+func convertedTotalSQL(reference string) (string, error) {
+	referenceExponent, err := money.Exponent(reference)
+	if err != nil {
+		return "", err
+	}
+
+	var exponents strings.Builder
+	exponents.WriteString("CASE payments.currency")
+	for _, currency := range money.Currencies() {
+		exponent, err := money.Exponent(currency)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&exponents, " WHEN '%s' THEN %d", currency, exponent)
+	}
+	exponents.WriteString(" END")
+
+	return fmt.Sprintf(
+		"ROUND(SUM((payments.amount_minor - payments.refunded_amount_minor) * donation_rates.rate * POWER(10::numeric, %d - %s)))::bigint",
+		referenceExponent, exponents.String(),
+	), nil
+}
+
+type messageKey struct {
+	userID    uint
+	anonymous bool
+}
+
+// latestMessages returns the most recent approved message on a counted payment credited
+// to each user, separately for their named and anonymous donations
+func (s *LeaderboardService) latestMessages(userIDs []uint) (map[messageKey]string, error) {
+	messages := make(map[messageKey]string)
+	if len(userIDs) == 0 {
+		return messages, nil
+	}
+
+	var rows []struct {
+		UserID    uint
+		Anonymous bool
+		Message   string
+	}
+	if err := s.db.Model(&models.Payment{}).
+		Select("DISTINCT ON (credited_user_id, anonymous) credited_user_id AS user_id, anonymous, message").
+		Where("credited_user_id IN ?", userIDs).
+		Where("message_status = ?", constants.MessageStatusApproved).
+		Where("status IN ?", models.CountedPaymentStatuses).
+		Order("credited_user_id, anonymous, created_at DESC").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		messages[messageKey{row.UserID, row.Anonymous}] = row.Message
+	}
+	return messages, nil
+}
@@ -0,0 +1,388 @@
+package services
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// The all-time, current month and current week lists are materialized in Redis, one
+// sorted set per currency (and the combined list) and period, scored by the donor's
+// total in minor units. A hash next to each set keeps the payment count and last
+// donation time of every entry. Names, privacy and messages are still read from the
+// database, for the requested page only, so changes to them show up immediately.
+const (
+	leaderboardKeyPrefix   = "leaderboard:"
+	leaderboardBuiltKey    = "leaderboard:built"
+	leaderboardUpdatedKey  = "leaderboard:updated_at"
+	leaderboardMetaSuffix  = ":meta"
+	leaderboardCombinedKey = "combined"
+)
+
+// leaderboardPeriodGrace keeps a finished month or week in Redis a little longer, so
+// late refunds of its payments still have a list to update
+const leaderboardPeriodGrace = 24 * time.Hour
+
+var errLeaderboardNotBuilt = stderrors.New("leaderboard has not been built in Redis")
+
+// cacheKey names the sorted set a query is served from, if it is materialized
+func (s *LeaderboardService) cacheKey(query LeaderboardQuery) (string, bool) {
+	if query.CampaignID != nil {
+		return "", false
+	}
+
+	scope := query.Currency
+	if query.Combined {
+		scope = leaderboardCombinedKey
+	}
+
+	switch query.Period {
+	case constants.LeaderboardPeriodAll:
+		return leaderboardKeyPrefix + scope + ":" + query.Period, true
+	case constants.LeaderboardPeriodMonth, constants.LeaderboardPeriodWeek:
+		if query.From == nil {
+			return "", false
+		}
+		return leaderboardKeyPrefix + scope + ":" + query.Period + ":" + query.From.Format("2006-01-02"), true
+	default:
+		return "", false
+	}
+}
+
+// periodQueries returns the materialized lists of a scope that cover t: all-time and the
+// month and week containing t
+func periodQueries(scope LeaderboardQuery, t time.Time) []LeaderboardQuery {
+	all := scope
+	all.Period = constants.LeaderboardPeriodAll
+	queries := []LeaderboardQuery{all}
+
+	for _, period := range []string{constants.LeaderboardPeriodMonth, constants.LeaderboardPeriodWeek} {
+		from, to := LeaderboardPeriodBounds(period, t)
+		query := scope
+		query.Period = period
+		query.From, query.To = &from, &to
+		queries = append(queries, query)
+	}
+	return queries
+}
+
+// scopes returns one query per currency and the combined one
+func scopes() []LeaderboardQuery {
+	var queries []LeaderboardQuery
+	for _, currency := range money.Currencies() {
+		queries = append(queries, LeaderboardQuery{Currency: currency})
+	}
+	return append(queries, LeaderboardQuery{Combined: true})
+}
+
+func (s *LeaderboardService) cachedTopUsers(key string, query LeaderboardQuery) ([]models.TopUserResponse, error) {
+	ctx := context.Background()
+
+	built, err := s.redis.Exists(ctx, leaderboardBuiltKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if built == 0 {
+		return nil, errLeaderboardNotBuilt
+	}
+
+	members, err := s.redis.ZRevRangeWithScores(ctx, key, int64(query.Offset), int64(query.Offset+query.Limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return []models.TopUserResponse{}, nil
+	}
+
+	names := make([]string, len(members))
+	for i, member := range members {
+		names[i] = member.Member.(string)
+	}
+	meta, err := s.redis.HMGet(ctx, key+leaderboardMetaSuffix, names...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	// An entry's rank is one more than the number of entries with a higher total
+	pipe := s.redis.Pipeline()
+	ahead := make([]*redis.IntCmd, len(members))
+	for i, member := range members {
+		ahead[i] = pipe.ZCount(ctx, key, "("+strconv.FormatFloat(member.Score, 'f', -1, 64), "+inf")
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	rows := make([]leaderboardRow, len(members))
+	userIDs := make([]uint, len(members))
+	for i, member := range members {
+		userID, anonymous, err := parseLeaderboardMember(names[i])
+		if err != nil {
+			return nil, err
+		}
+		count, lastDonationAt, err := parseLeaderboardMeta(meta[i])
+		if err != nil {
+			return nil, fmt.Errorf("entry %s: %w", names[i], err)
+		}
+		rows[i] = leaderboardRow{
+			Rank:           int(ahead[i].Val()) + 1,
+			UserID:         userID,
+			Anonymous:      anonymous,
+			TotalAmount:    int64(math.Round(member.Score)),
+			PaymentCount:   count,
+			LastDonationAt: lastDonationAt,
+		}
+		userIDs[i] = userID
+	}
+
+	var users []models.User
+	if err := s.db.Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]*models.User, len(users))
+	for i := range users {
+		byID[users[i].ID] = &users[i]
+	}
+	for i := range rows {
+		if user, ok := byID[rows[i].UserID]; ok {
+			rows[i].Name, rows[i].InstagramID, rows[i].Privacy = user.Name, user.InstagramID, user.Privacy
+		}
+	}
+
+	// Redis orders equal totals by member, the database by who got there first
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Rank != rows[j].Rank {
+			return rows[i].Rank < rows[j].Rank
+		}
+		return rows[i].LastDonationAt.Before(rows[j].LastDonationAt)
+	})
+
+	return s.entries(rows, s.currency(query))
+}
+
+// HandleTransition refreshes the credited user's entries in the lists a payment counts
+// toward whenever it starts or stops counting or its refunded amount changes. Entries are
+// recomputed from the database rather than adjusted, so a repeated update is harmless.
+func (s *LeaderboardService) HandleTransition(transition Transition) {
+	if !isCountedStatus(transition.From) && !isCountedStatus(transition.To) {
+		return
+	}
+
+	s.refreshPayment(&transition.Payment)
+	s.Touch()
+}
+
+// HandleClaim adds gifts to their recipient's entries once the recipient claimed them
+func (s *LeaderboardService) HandleClaim(records []models.Payment) {
+	for i := range records {
+		if isCountedStatus(records[i].Status) {
+			s.refreshPayment(&records[i])
+		}
+	}
+	s.Touch()
+}
+
+func (s *LeaderboardService) refreshPayment(record *models.Payment) {
+	// Unclaimed gifts don't count for anyone yet
+	if record.CreditedUserID == nil {
+		return
+	}
+
+	for _, scope := range []LeaderboardQuery{{Currency: record.Currency}, {Combined: true}} {
+		for _, query := range periodQueries(scope, record.CreatedAt) {
+			if err := s.refreshEntry(query, *record.CreditedUserID, record.Anonymous); err != nil {
+				log.Printf("Failed to update leaderboard for payment %d: %v", record.ID, err)
+			}
+		}
+	}
+}
+
+func (s *LeaderboardService) refreshEntry(query LeaderboardQuery, userID uint, anonymous bool) error {
+	key, ok := s.cacheKey(query)
+	if !ok {
+		return nil
+	}
+
+	db, err := s.rankQuery(query)
+	if err != nil {
+		return err
+	}
+	var rows []leaderboardRow
+	if err := db.Where("payments.credited_user_id = ? AND payments.anonymous = ?", userID, anonymous).Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	member := leaderboardMember(userID, anonymous)
+	pipe := s.redis.TxPipeline()
+	if len(rows) == 0 {
+		pipe.ZRem(ctx, key, member)
+		pipe.HDel(ctx, key+leaderboardMetaSuffix, member)
+	} else {
+		pipe.ZAdd(ctx, key, &redis.Z{Score: float64(rows[0].TotalAmount), Member: member})
+		pipe.HSet(ctx, key+leaderboardMetaSuffix, member, leaderboardMeta(rows[0]))
+	}
+	if query.To != nil {
+		pipe.ExpireAt(ctx, key, query.To.Add(leaderboardPeriodGrace))
+		pipe.ExpireAt(ctx, key+leaderboardMetaSuffix, query.To.Add(leaderboardPeriodGrace))
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Rebuild recomputes every materialized list from the database, for recovery after Redis
+// lost data or missed updates. Each list is built under a temporary key and swapped in.
+func (s *LeaderboardService) Rebuild() error {
+	now := time.Now()
+	if err := s.rebuild(scopes(), now); err != nil {
+		return err
+	}
+
+	if err := s.redis.Set(context.Background(), leaderboardBuiltKey, now.Unix(), 0).Err(); err != nil {
+		return err
+	}
+	s.Touch()
+	return nil
+}
+
+// RebuildCombined recomputes the combined lists, needed once a currency gets its first
+// exchange rate and its earlier donations start to count
+func (s *LeaderboardService) RebuildCombined() error {
+	if err := s.rebuild([]LeaderboardQuery{{Combined: true}}, time.Now()); err != nil {
+		return err
+	}
+	s.Touch()
+	return nil
+}
+
+func (s *LeaderboardService) rebuild(scopes []LeaderboardQuery, now time.Time) error {
+	ctx := context.Background()
+
+	for _, scope := range scopes {
+		for _, query := range periodQueries(scope, now) {
+			key, _ := s.cacheKey(query)
+
+			db, err := s.rankQuery(query)
+			if err != nil {
+				return err
+			}
+			var rows []leaderboardRow
+			if err := db.Scan(&rows).Error; err != nil {
+				return fmt.Errorf("failed to rank %s: %w", key, err)
+			}
+
+			building := key + ":rebuild"
+			pipe := s.redis.TxPipeline()
+			pipe.Del(ctx, building, building+leaderboardMetaSuffix)
+			if len(rows) == 0 {
+				pipe.Del(ctx, key, key+leaderboardMetaSuffix)
+			} else {
+				members := make([]*redis.Z, len(rows))
+				meta := make([]interface{}, 0, 2*len(rows))
+				for i, row := range rows {
+					member := leaderboardMember(row.UserID, row.Anonymous)
+					members[i] = &redis.Z{Score: float64(row.TotalAmount), Member: member}
+					meta = append(meta, member, leaderboardMeta(row))
+				}
+				pipe.ZAdd(ctx, building, members...)
+				pipe.HSet(ctx, building+leaderboardMetaSuffix, meta...)
+				pipe.Rename(ctx, building, key)
+				pipe.Rename(ctx, building+leaderboardMetaSuffix, key+leaderboardMetaSuffix)
+				if query.To != nil {
+					pipe.ExpireAt(ctx, key, query.To.Add(leaderboardPeriodGrace))
+					pipe.ExpireAt(ctx, key+leaderboardMetaSuffix, query.To.Add(leaderboardPeriodGrace))
+				}
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				return fmt.Errorf("failed to store %s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// EnsureBuilt rebuilds the lists unless Redis already has them
+func (s *LeaderboardService) EnsureBuilt() error {
+	built, err := s.redis.Exists(context.Background(), leaderboardBuiltKey).Result()
+	if err != nil {
+		return err
+	}
+	if built > 0 {
+		return nil
+	}
+	return s.Rebuild()
+}
+
+// Touch records that the leaderboards changed, including changes to how entries are
+// shown such as a donor's privacy or a moderated message
+func (s *LeaderboardService) Touch() {
+	if err := s.redis.Set(context.Background(), leaderboardUpdatedKey, time.Now().UnixNano(), 0).Err(); err != nil {
+		log.Printf("Failed to mark the leaderboard as updated: %v", err)
+	}
+}
+
+// LastModified returns when the leaderboards last changed, zero if unknown
+func (s *LeaderboardService) LastModified() (time.Time, error) {
+	nanos, err := s.redis.Get(context.Background(), leaderboardUpdatedKey).Int64()
+	if stderrors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, nanos), nil
+}
+
+func isCountedStatus(status string) bool {
+	for _, counted := range models.CountedPaymentStatuses {
+		if counted == status {
+			return true
+		}
+	}
+	return false
+}
+
+func leaderboardMember(userID uint, anonymous bool) string {
+	return strconv.FormatUint(uint64(userID), 10) + ":" + strconv.FormatBool(anonymous)
+}
+
+func parseLeaderboardMember(member string) (uint, bool, error) {
+	id, anonymous, _ := strings.Cut(member, ":")
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid leaderboard member %q", member)
+	}
+	return uint(userID), anonymous == "true", nil
+}
+
+func leaderboardMeta(row leaderboardRow) string {
+	return strconv.Itoa(row.PaymentCount) + "|" + row.LastDonationAt.UTC().Format(time.RFC3339Nano)
+}
+
+func parseLeaderboardMeta(value interface{}) (int, time.Time, error) {
+	meta, ok := value.(string)
+	if !ok {
+		return 0, time.Time{}, stderrors.New("missing leaderboard metadata")
+	}
+	count, at, _ := strings.Cut(meta, "|")
+	paymentCount, err := strconv.Atoi(count)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	lastDonationAt, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return paymentCount, lastDonationAt, nil
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	stderrors "errors"
+	"strings"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/moderation"
+
+	"gorm.io/gorm"
+)
+
+var ErrMessageNotFound = stderrors.New("payment has no message")
+
+// ModerationService decides which donation messages are shown publicly. Messages the
+// filter flags always wait for a moderator; clean ones are approved straight away unless
+// autoApprove is off.
+type ModerationService struct {
+	db             *gorm.DB
+	filter         *moderation.Filter
+	autoApprove    bool
+	paymentService *PaymentService
+	leaderboard    *LeaderboardService
+}
+
+func NewModerationService(db *gorm.DB, filter *moderation.Filter, autoApprove bool, paymentService *PaymentService, leaderboard *LeaderboardService) *ModerationService {
+	return &ModerationService{
+		db:             db,
+		filter:         filter,
+		autoApprove:    autoApprove,
+		paymentService: paymentService,
+		leaderboard:    leaderboard,
+	}
+}
+
+// Screen returns the initial message status and the filter's flags, comma separated
+func (s *ModerationService) Screen(message string) (string, string) {
+	flags := s.filter.Check(message)
+	if len(flags) == 0 && s.autoApprove {
+		return constants.MessageStatusApproved, ""
+	}
+	return constants.MessageStatusPending, strings.Join(flags, ",")
+}
+
+// Moderate approves or rejects the message of a payment on behalf of an admin.
+func (s *ModerationService) Moderate(paymentID uint, approve bool, adminID uint) (*models.Payment, error) {
+	var record models.Payment
+	if err := s.db.First(&record, paymentID).Error; err != nil {
+		return nil, err
+	}
+	if record.Message == "" {
+		return nil, ErrMessageNotFound
+	}
+
+	status := constants.MessageStatusRejected
+	if approve {
+		status = constants.MessageStatusApproved
+	}
+
+	from := record.MessageStatus
+	if err := s.db.Model(&record).Update("message_status", status).Error; err != nil {
+		return nil, err
+	}
+	record.MessageStatus = status
+	// Approved messages are shown next to the donor on the leaderboard
+	s.leaderboard.Touch()
+
+	if err := s.paymentService.CreateLog(record.ID, constants.PaymentEventMessageModerated, map[string]interface{}{
+		"from":     from,
+		"to":       status,
+		"admin_id": adminID,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"log"
+	"time"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/telegram"
+
+	"gorm.io/gorm"
+)
+
+// NotificationService announces payment transitions to the outside world.
+type NotificationService struct {
+	db              *gorm.DB
+	telegramService *telegram.TelegramService
+	donationFeed    *DonationFeedService
+}
+
+func NewNotificationService(db *gorm.DB, telegramService *telegram.TelegramService, donationFeed *DonationFeedService) *NotificationService {
+	return &NotificationService{
+		db:              db,
+		telegramService: telegramService,
+		donationFeed:    donationFeed,
+	}
+}
+
+func (s *NotificationService) HandleTransition(transition Transition) {
+	switch transition.To {
+	case constants.PaymentStatusCompleted, constants.PaymentStatusRefunded, constants.PaymentStatusPartiallyRefunded:
+	default:
+		return
+	}
+
+	var user models.User
+	if err := s.db.Where("id = ?", transition.Payment.UserID).First(&user).Error; err != nil {
+		log.Printf("Failed to find user for payment %d: %v", transition.Payment.ID, err)
+		return
+	}
+
+	name, instagramID := models.PublicIdentity(user.Name, user.InstagramID, user.Privacy, transition.Payment.Anonymous)
+
+	var err error
+	if transition.To == constants.PaymentStatusCompleted {
+		amount := transition.Payment.Money()
+		if err := s.donationFeed.Publish(Donation{
+			PaymentID:   transition.Payment.ID,
+			Name:        name,
+			InstagramID: instagramID,
+			Amount:      amount.Decimal(),
+			Currency:    amount.Currency,
+			AmountMinor: amount.Amount,
+			Message:     transition.Payment.ApprovedMessage(),
+			At:          time.Now(),
+		}); err != nil {
+			log.Printf("Failed to publish donation %d to the feed: %v", transition.Payment.ID, err)
+		}
+
+		err = s.telegramService.SendPaymentNotification(
+			name,
+			instagramID,
+			transition.Payment.ApprovedMessage(),
+			transition.Payment.Money(),
+			time.Now(),
+		)
+	} else {
+		err = s.telegramService.SendRefundNotification(
+			name,
+			instagramID,
+			transition.Payment.RefundedMoney(),
+			transition.Payment.Money(),
+			time.Now(),
+		)
+	}
+	if err != nil {
+		log.Printf("Failed to send Telegram notification: %v", err)
+	}
+}
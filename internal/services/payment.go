@@ -0,0 +1,239 @@
+package services
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"log"
+	"time"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/errors"
+	"vinak/pkg/payment"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Transition describes a status change that has been committed.
+type Transition struct {
+	Payment models.Payment
+	From    string
+	To      string
+}
+
+// errTransitionRejected rolls back a transition the state machine doesn't allow, together
+// with anything its change already wrote
+var errTransitionRejected = stderrors.New("payment transition not allowed")
+
+// TransitionHook runs after a transition commits. Hooks must not block for long.
+type TransitionHook func(transition Transition)
+
+type PaymentService struct {
+	db       *gorm.DB
+	gateways *payment.Registry
+	hooks    []TransitionHook
+}
+
+func NewPaymentService(db *gorm.DB, gateways *payment.Registry) *PaymentService {
+	return &PaymentService{
+		db:       db,
+		gateways: gateways,
+	}
+}
+
+func (s *PaymentService) OnTransition(hook TransitionHook) {
+	s.hooks = append(s.hooks, hook)
+}
+
+// Transition moves the payment to status under a row lock, recording the change in the
+// payment log. Transitions the state machine doesn't allow (replayed or late callbacks)
+// are logged and leave the payment untouched; changed reports which case happened.
+func (s *PaymentService) Transition(paymentID uint, status, transactionID string, data map[string]interface{}) (*models.Payment, bool, error) {
+	return s.transition(paymentID, data, func(tx *gorm.DB, record *models.Payment) (string, error) {
+		if transactionID != "" {
+			record.GatewayTransactionID = &transactionID
+		}
+		return status, nil
+	})
+}
+
+// paymentChange picks the status a locked payment moves to, applying any changes that go
+// with it to record. Returning an empty status leaves the payment as it is. Whatever the
+// change wrote is rolled back unless the payment moves.
+type paymentChange func(tx *gorm.DB, record *models.Payment) (string, error)
+
+func (s *PaymentService) transition(paymentID uint, data map[string]interface{}, change paymentChange) (*models.Payment, bool, error) {
+	var record models.Payment
+	var from, status string
+	var logData map[string]interface{}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&record, paymentID).Error; err != nil {
+			return err
+		}
+
+		logData = map[string]interface{}{"gateway": record.Gateway}
+		for key, value := range data {
+			logData[key] = value
+		}
+
+		current := record
+		var err error
+		if status, err = change(tx, &record); err != nil {
+			return err
+		}
+
+		if status == "" || !models.CanTransitionPayment(current.Status, status) {
+			record = current
+			return errTransitionRejected
+		}
+
+		from = record.Status
+		logData["from"] = from
+		logData["to"] = status
+
+		// Refunds reported without an amount (e.g. a reversed Zarinpal payment) cover
+		// whatever hadn't been refunded yet
+		if status == constants.PaymentStatusRefunded && record.RefundedAmountMinor < record.AmountMinor {
+			refund := models.Refund{
+				PaymentID:   record.ID,
+				AmountMinor: record.AmountMinor - record.RefundedAmountMinor,
+				Currency:    record.Currency,
+				Status:      constants.RefundStatusCompleted,
+				Reason:      "reported by gateway",
+			}
+			if err := tx.Create(&refund).Error; err != nil {
+				return err
+			}
+			record.RefundedAmountMinor = record.AmountMinor
+			logData["refund_id"] = refund.ID
+		}
+
+		record.Status = status
+		if err := tx.Save(&record).Error; err != nil {
+			return err
+		}
+
+		return s.createLog(tx, record.ID, constants.PaymentEventStatusChanged, logData)
+	})
+	if stderrors.Is(err, errTransitionRejected) {
+		// Logged outside the rolled back transaction so the notification is still recorded
+		logData["status"] = status
+		logData["current_status"] = record.Status
+		if err := s.createLog(s.db, record.ID, constants.PaymentEventNotificationReceived, logData); err != nil {
+			return nil, false, err
+		}
+		return &record, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, hook := range s.hooks {
+		hook(Transition{Payment: record, From: from, To: status})
+	}
+
+	return &record, true, nil
+}
+
+// Sync asks the gateway where an open payment stands and applies the result. Payments the
+// gateway approved but whose callback never arrived are verified/captured here. Statuses
+// the state machine can't reach from the local one are logged as discrepancies. The
+// gateway's answer is returned alongside the payment, nil if it wasn't asked.
+func (s *PaymentService) Sync(record *models.Payment) (*models.Payment, *payment.StatusResult, error) {
+	if record.GatewayReference == nil {
+		return record, nil, nil
+	}
+
+	gateway, err := s.gateways.Get(record.Gateway)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if err := s.db.Model(record).UpdateColumn("last_checked_at", now).Error; err != nil {
+		return nil, nil, err
+	}
+	record.LastCheckedAt = &now
+
+	status, err := gateway.GetPaymentStatus(*record.GatewayReference)
+	if err != nil {
+		return nil, nil, errors.NewPaymentGatewayError(gateway.Name(), err)
+	}
+
+	target, transactionID := status.Status, ""
+	if target == constants.PaymentStatusAwaitingConfirmation {
+		result, err := gateway.VerifyPayment(payment.VerifyParams{
+			Reference: *record.GatewayReference,
+			Amount:    record.ChargedMoney(),
+		})
+		if err != nil {
+			log.Printf("Failed to verify %s payment %d during sync: %v", gateway.Name(), record.ID, err)
+		} else {
+			target, transactionID = result.Status, result.TransactionID
+		}
+	}
+
+	if target == record.Status {
+		return record, status, nil
+	}
+
+	data := map[string]interface{}{
+		"source":         constants.PaymentSourceReconciliation,
+		"gateway_status": status.GatewayStatus,
+	}
+
+	if !models.CanTransitionPayment(record.Status, target) {
+		data["local_status"] = record.Status
+		data["gateway_mapped_status"] = target
+		if err := s.CreateLog(record.ID, constants.PaymentEventReconciliationMismatch, data); err != nil {
+			return nil, nil, err
+		}
+		return record, status, nil
+	}
+
+	updated, _, err := s.Transition(record.ID, target, transactionID, data)
+	return updated, status, err
+}
+
+// Expire closes an abandoned open payment. The gateway is asked first so a payment that
+// went through at the last moment gets settled instead. Nothing is cancelled upstream:
+// the gateway lets the order lapse, and one paid late still completes the payment.
+func (s *PaymentService) Expire(record *models.Payment) (*models.Payment, error) {
+	if record.GatewayReference != nil {
+		synced, _, err := s.Sync(record)
+		if err != nil {
+			// Expiring is still safe, a late completion can move it out of expired
+			log.Printf("Failed to sync payment %d before expiring it: %v", record.ID, err)
+		} else if !models.IsOpenPaymentStatus(synced.Status) {
+			return synced, nil
+		}
+	}
+
+	updated, _, err := s.Transition(record.ID, constants.PaymentStatusExpired, "", map[string]interface{}{
+		"source": constants.PaymentSourceExpiry,
+	})
+	return updated, err
+}
+
+func (s *PaymentService) CreateLog(paymentID uint, event string, data map[string]interface{}) error {
+	return s.createLog(s.db, paymentID, event, data)
+}
+
+func (s *PaymentService) createLog(db *gorm.DB, paymentID uint, event string, data map[string]interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Create(&models.PaymentLog{
+		PaymentID: paymentID,
+		Event:     event,
+		Data:      string(encoded),
+	}).Error; err != nil {
+		log.Printf("Failed to create payment log for payment %d: %v", paymentID, err)
+		return err
+	}
+
+	return nil
+}
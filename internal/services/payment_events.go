@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// PaymentEvent is a committed status change as pushed to live subscribers.
+type PaymentEvent struct {
+	PaymentID      uint        `json:"payment_id"`
+	From           string      `json:"from"`
+	To             string      `json:"to"`
+	RefundedAmount json.Number `json:"refunded_amount"`
+	Currency       string      `json:"currency"`
+	At             time.Time   `json:"at"`
+}
+
+// PaymentEventService fans payment transitions out over Redis pub/sub, so a subscriber
+// connected to any replica sees transitions committed on every other one.
+type PaymentEventService struct {
+	redis *redis.Client
+}
+
+func NewPaymentEventService(redis *redis.Client) *PaymentEventService {
+	return &PaymentEventService{
+		redis: redis,
+	}
+}
+
+func (s *PaymentEventService) HandleTransition(transition Transition) {
+	if err := publish(s.redis, s.channel(transition.Payment.ID), PaymentEvent{
+		PaymentID:      transition.Payment.ID,
+		From:           transition.From,
+		To:             transition.To,
+		RefundedAmount: transition.Payment.RefundedMoney().Decimal(),
+		Currency:       transition.Payment.Currency,
+		At:             transition.Payment.UpdatedAt,
+	}); err != nil {
+		log.Printf("Failed to publish event for payment %d: %v", transition.Payment.ID, err)
+	}
+}
+
+// Subscribe streams the events of a payment until ctx is done
+func (s *PaymentEventService) Subscribe(ctx context.Context, paymentID uint) (<-chan PaymentEvent, error) {
+	return subscribe[PaymentEvent](ctx, s.redis, s.channel(paymentID))
+}
+
+func (s *PaymentEventService) channel(paymentID uint) string {
+	return "payment_events:" + strconv.FormatUint(uint64(paymentID), 10)
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	stderrors "errors"
+	"strings"
+	"time"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPromoCodeNotFound  = stderrors.New("promo code not found")
+	ErrPromoCodeNotActive = stderrors.New("promo code is not active")
+	ErrPromoCodeUsedUp    = stderrors.New("promo code has no uses left")
+	ErrPromoCodeUserLimit = stderrors.New("user has no uses of the promo code left")
+	ErrAmountBelowMinimum = stderrors.New("amount is below the minimum donation")
+)
+
+// redeemedPaymentStatuses are the payments that use up a promo code. Payments that
+// failed, expired or were refunded give their use back.
+var redeemedPaymentStatuses = []string{
+	constants.PaymentStatusPending,
+	constants.PaymentStatusAwaitingConfirmation,
+	constants.PaymentStatusCompleted,
+	constants.PaymentStatusPartiallyRefunded,
+}
+
+// PromoService applies minimum donation amounts and the promo codes that change them.
+type PromoService struct {
+	db *gorm.DB
+	// minAmounts is the smallest donation per currency, in minor units
+	minAmounts map[string]int64
+}
+
+func NewPromoService(db *gorm.DB, minAmounts map[string]int64) *PromoService {
+	return &PromoService{
+		db:         db,
+		minAmounts: minAmounts,
+	}
+}
+
+// NormalizeCode returns a promo code as it is stored, so codes match regardless of case
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Find returns a promo code that can be redeemed now
+func (s *PromoService) Find(code string) (*models.PromoCode, error) {
+	var promo models.PromoCode
+	err := s.db.Where("code = ?", NormalizeCode(code)).First(&promo).Error
+	if stderrors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPromoCodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !promo.Available(time.Now()) {
+		return nil, ErrPromoCodeNotActive
+	}
+	return &promo, nil
+}
+
+// MinAmount returns the smallest donation accepted in currency, with promo applied if
+// it isn't nil
+func (s *PromoService) MinAmount(promo *models.PromoCode, currency string) money.Money {
+	if promo != nil {
+		if amount, ok := promo.MinAmounts[currency]; ok {
+			return money.New(amount, currency)
+		}
+	}
+	return money.New(s.minAmounts[currency], currency)
+}
+
+// CheckAmount rejects a donation below the minimum for its currency
+func (s *PromoService) CheckAmount(promo *models.PromoCode, amount money.Money) error {
+	if amount.Amount < s.MinAmount(promo, amount.Currency).Amount {
+		return ErrAmountBelowMinimum
+	}
+	return nil
+}
+
+// Uses returns how many payments redeemed each of the given promo codes
+func (s *PromoService) Uses(promoIDs []uint) (map[uint]int64, error) {
+	var rows []struct {
+		PromoCodeID uint
+		Uses        int64
+	}
+	err := s.db.Model(&models.Payment{}).
+		Select("promo_code_id, COUNT(*) AS uses").
+		Where("promo_code_id IN ? AND status IN ?", promoIDs, redeemedPaymentStatuses).
+		Group("promo_code_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	uses := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		uses[row.PromoCodeID] = row.Uses
+	}
+	return uses, nil
+}
+
+// CreateWithPromo creates a payment that redeems a promo code and logs the redemption.
+// The code is locked while its usage limits are checked, so concurrent payments can't
+// redeem it more often than allowed.
+func (s *PaymentService) CreateWithPromo(record *models.Payment, promo *models.PromoCode) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var locked models.PromoCode
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, promo.ID).Error; err != nil {
+			return err
+		}
+		if !locked.Available(time.Now()) {
+			return ErrPromoCodeNotActive
+		}
+
+		if err := checkPromoUses(tx, &locked, record.UserID); err != nil {
+			return err
+		}
+
+		record.PromoCodeID = &locked.ID
+		record.TierBonusMinor = locked.TierBonus(record.Money())
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+
+		return s.createLog(tx, record.ID, constants.PaymentEventPromoCodeRedeemed, map[string]interface{}{
+			"promo_code_id": locked.ID,
+			"code":          locked.Code,
+			"bonus_percent": locked.BonusPercent,
+			"tier_bonus":    money.New(record.TierBonusMinor, record.Currency).Decimal(),
+		})
+	})
+}
+
+func checkPromoUses(db *gorm.DB, promo *models.PromoCode, userID uint) error {
+	if promo.MaxUses > 0 {
+		var uses int64
+		if err := db.Model(&models.Payment{}).
+			Where("promo_code_id = ? AND status IN ?", promo.ID, redeemedPaymentStatuses).
+			Count(&uses).Error; err != nil {
+			return err
+		}
+		if uses >= int64(promo.MaxUses) {
+			return ErrPromoCodeUsedUp
+		}
+	}
+
+	if promo.MaxUsesPerUser > 0 {
+		var uses int64
+		if err := db.Model(&models.Payment{}).
+			Where("promo_code_id = ? AND user_id = ? AND status IN ?", promo.ID, userID, redeemedPaymentStatuses).
+			Count(&uses).Error; err != nil {
+			return err
+		}
+		if uses >= int64(promo.MaxUsesPerUser) {
+			return ErrPromoCodeUserLimit
+		}
+	}
+	return nil
+}
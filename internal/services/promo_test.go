@@ -0,0 +1,214 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
+	"vinak/pkg/payment"
+)
+
+func TestPromoServiceCheckAmount(t *testing.T) {
+	service := NewPromoService(nil, map[string]int64{
+		constants.CurrencyUSD: 500,
+		constants.CurrencyIRT: 50000,
+	})
+	promo := &models.PromoCode{
+		Code:       "LAUNCH",
+		MinAmounts: map[string]int64{constants.CurrencyUSD: 100},
+	}
+
+	tests := []struct {
+		name    string
+		promo   *models.PromoCode
+		amount  money.Money
+		wantMin int64
+		wantErr error
+	}{
+		{"at minimum", nil, money.New(500, constants.CurrencyUSD), 500, nil},
+		{"above minimum", nil, money.New(501, constants.CurrencyUSD), 500, nil},
+		{"below minimum", nil, money.New(499, constants.CurrencyUSD), 500, ErrAmountBelowMinimum},
+		{"promo lowers minimum", promo, money.New(100, constants.CurrencyUSD), 100, nil},
+		{"below promo minimum", promo, money.New(99, constants.CurrencyUSD), 100, ErrAmountBelowMinimum},
+		{"promo keeps other currencies", promo, money.New(49999, constants.CurrencyIRT), 50000, ErrAmountBelowMinimum},
+		{"promo at other currency minimum", promo, money.New(50000, constants.CurrencyIRT), 50000, nil},
+		{"no configured minimum", nil, money.New(1, constants.CurrencyBTC), 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			minimum := service.MinAmount(tt.promo, tt.amount.Currency)
+			if minimum != money.New(tt.wantMin, tt.amount.Currency) {
+				t.Errorf("MinAmount() = %+v, want %d", minimum, tt.wantMin)
+			}
+			if err := service.CheckAmount(tt.promo, tt.amount); !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckAmount(%+v) error = %v, want %v", tt.amount, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"LAUNCH", "LAUNCH"},
+		{"launch", "LAUNCH"},
+		{"  Launch-2024 ", "LAUNCH-2024"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := NormalizeCode(tt.code); got != tt.want {
+				t.Errorf("NormalizeCode(%q) = %q, want %q", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestPromoPayment(user *models.User) *models.Payment {
+	return &models.Payment{
+		UserID:             user.ID,
+		AmountMinor:        1000,
+		ChargedAmountMinor: 1000,
+		ChargedCurrency:    constants.CurrencyUSD,
+		Currency:           constants.CurrencyUSD,
+		Status:             constants.PaymentStatusPending,
+		Gateway:            constants.PaymentGatewayPayPal,
+	}
+}
+
+func TestCreateWithPromoLimits(t *testing.T) {
+	db := newTestDB(t)
+	payments := NewPaymentService(db, payment.NewRegistry())
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	promo := models.PromoCode{Code: "LAUNCH", BonusPercent: 10, MaxUses: 3, MaxUsesPerUser: 2, Active: true}
+	if err := db.Create(&promo).Error; err != nil {
+		t.Fatalf("failed to create promo code: %v", err)
+	}
+
+	steps := []struct {
+		name string
+		user *models.User
+		want error
+	}{
+		{"first use", alice, nil},
+		{"second use by the same user", alice, nil},
+		{"over the per-user limit", alice, ErrPromoCodeUserLimit},
+		{"another user", bob, nil},
+		{"over the total limit", bob, ErrPromoCodeUsedUp},
+	}
+
+	var first *models.Payment
+	for _, step := range steps {
+		record := newTestPromoPayment(step.user)
+		err := payments.CreateWithPromo(record, &promo)
+		if !errors.Is(err, step.want) {
+			t.Fatalf("%s: CreateWithPromo() error = %v, want %v", step.name, err, step.want)
+		}
+		if err != nil {
+			if record.ID != 0 {
+				t.Errorf("%s: rejected payment was created", step.name)
+			}
+			continue
+		}
+		if record.PromoCodeID == nil || *record.PromoCodeID != promo.ID || record.TierBonusMinor != 100 {
+			t.Errorf("%s: payment promo = %v bonus %d, want %d bonus 100", step.name, record.PromoCodeID, record.TierBonusMinor, promo.ID)
+		}
+		if first == nil {
+			first = record
+		}
+	}
+
+	// A failed payment gives its use back
+	if _, _, err := payments.Transition(first.ID, constants.PaymentStatusFailed, "", nil); err != nil {
+		t.Fatalf("Transition() error = %v", err)
+	}
+	if err := payments.CreateWithPromo(newTestPromoPayment(alice), &promo); err != nil {
+		t.Errorf("CreateWithPromo() after a failed payment error = %v", err)
+	}
+
+	service := NewPromoService(db, nil)
+	uses, err := service.Uses([]uint{promo.ID})
+	if err != nil {
+		t.Fatalf("Uses() error = %v", err)
+	}
+	if uses[promo.ID] != 3 {
+		t.Errorf("Uses() = %d, want 3", uses[promo.ID])
+	}
+
+	var redemptions int64
+	db.Model(&models.PaymentLog{}).Where("event = ?", constants.PaymentEventPromoCodeRedeemed).Count(&redemptions)
+	if redemptions != 4 {
+		t.Errorf("logged %d redemptions, want 4", redemptions)
+	}
+}
+
+func TestCreateWithPromoConcurrent(t *testing.T) {
+	db := newTestDB(t)
+	payments := NewPaymentService(db, payment.NewRegistry())
+
+	promo := models.PromoCode{Code: "RUSH", MaxUses: 3, Active: true}
+	if err := db.Create(&promo).Error; err != nil {
+		t.Fatalf("failed to create promo code: %v", err)
+	}
+
+	users := make([]*models.User, 10)
+	for i := range users {
+		users[i] = createTestUser(t, db, fmt.Sprintf("donor%d", i))
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(users))
+	for i, user := range users {
+		wg.Add(1)
+		go func(i int, user *models.User) {
+			defer wg.Done()
+			errs[i] = payments.CreateWithPromo(newTestPromoPayment(user), &promo)
+		}(i, user)
+	}
+	wg.Wait()
+
+	redeemed := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			redeemed++
+		case !errors.Is(err, ErrPromoCodeUsedUp):
+			t.Errorf("CreateWithPromo() error = %v, want nil or %v", err, ErrPromoCodeUsedUp)
+		}
+	}
+	if redeemed != promo.MaxUses {
+		t.Errorf("%d payments redeemed the code, want %d", redeemed, promo.MaxUses)
+	}
+}
+
+func TestCreateWithPromoInactive(t *testing.T) {
+	db := newTestDB(t)
+	payments := NewPaymentService(db, payment.NewRegistry())
+	user := createTestUser(t, db, "donor")
+
+	promo := models.PromoCode{Code: "OLD", Active: true}
+	if err := db.Create(&promo).Error; err != nil {
+		t.Fatalf("failed to create promo code: %v", err)
+	}
+	// Deactivated after the donor looked it up
+	if err := db.Model(&promo).Update("active", false).Error; err != nil {
+		t.Fatalf("failed to deactivate promo code: %v", err)
+	}
+
+	record := newTestPromoPayment(user)
+	if err := payments.CreateWithPromo(record, &promo); !errors.Is(err, ErrPromoCodeNotActive) {
+		t.Errorf("CreateWithPromo() error = %v, want %v", err, ErrPromoCodeNotActive)
+	}
+	if record.ID != 0 {
+		t.Errorf("payment was created for an inactive code")
+	}
+}
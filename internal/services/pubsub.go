@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func publish(rdb *redis.Client, channel string, message interface{}) error {
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return rdb.Publish(context.Background(), channel, encoded).Err()
+}
+
+// subscribe decodes the JSON messages published on channel until ctx is done. The
+// subscription is active once it returns, so state read afterwards can't miss a message.
+func subscribe[T any](ctx context.Context, rdb *redis.Client, channel string) (<-chan T, error) {
+	pubsub := rdb.Subscribe(ctx, channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	messages := make(chan T)
+	go func() {
+		defer close(messages)
+		defer pubsub.Close()
+
+		received := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-received:
+				if !ok {
+					return
+				}
+				var decoded T
+				if err := json.Unmarshal([]byte(message.Payload), &decoded); err != nil {
+					log.Printf("Failed to decode message on %s: %v", channel, err)
+					continue
+				}
+				select {
+				case messages <- decoded:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return messages, nil
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	stderrors "errors"
+	"log"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/errors"
+	"vinak/pkg/money"
+	"vinak/pkg/payment"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPaymentNotRefundable = stderrors.New("only completed payments can be refunded")
+	ErrRefundExceedsBalance = stderrors.New("refund amount exceeds the refundable balance")
+	ErrRefundDeclined       = stderrors.New("gateway declined the refund")
+)
+
+type RefundRequest struct {
+	PaymentID uint
+	// Amount is in the payment's currency, nil refunds the whole refundable balance
+	Amount *money.Money
+	Reason string
+	// Manual records a refund that was settled outside the gateway. Gateways without
+	// a Refunder (NowPayments) only take manual refunds.
+	Manual  bool
+	AdminID uint
+}
+
+// Refund returns money on a completed payment. The refund is recorded as pending before
+// the gateway is called, so concurrent refunds can't exceed what was paid, and is
+// settled once the gateway confirms it, either right away or through a notification.
+func (s *PaymentService) Refund(req RefundRequest) (*models.Refund, *models.Payment, error) {
+	var record models.Payment
+	var refund models.Refund
+	var refunder payment.Refunder
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&record, req.PaymentID).Error; err != nil {
+			return err
+		}
+
+		if record.Status != constants.PaymentStatusCompleted && record.Status != constants.PaymentStatusPartiallyRefunded {
+			return ErrPaymentNotRefundable
+		}
+
+		if !req.Manual {
+			gateway, err := s.gateways.Get(record.Gateway)
+			if err != nil {
+				return err
+			}
+			if refunder, err = payment.CheckRefunder(gateway); err != nil {
+				return err
+			}
+		}
+
+		var pending int64
+		if err := tx.Model(&models.Refund{}).
+			Select("COALESCE(SUM(amount_minor), 0)").
+			Where("payment_id = ? AND status = ?", record.ID, constants.RefundStatusPending).
+			Scan(&pending).Error; err != nil {
+			return err
+		}
+
+		remaining := record.AmountMinor - record.RefundedAmountMinor - pending
+		amount := remaining
+		if req.Amount != nil {
+			amount = req.Amount.Amount
+		}
+		if amount <= 0 || amount > remaining {
+			return ErrRefundExceedsBalance
+		}
+
+		// Partial refunds can't be converted back into a different charged currency
+		if !req.Manual && record.ChargedCurrency != record.Currency && amount != record.AmountMinor {
+			return payment.ErrPartialRefundUnsupported
+		}
+
+		refund = models.Refund{
+			PaymentID:   record.ID,
+			AmountMinor: amount,
+			Currency:    record.Currency,
+			Status:      constants.RefundStatusPending,
+			Reason:      req.Reason,
+			Manual:      req.Manual,
+			CreatedByID: &req.AdminID,
+		}
+		if err := tx.Create(&refund).Error; err != nil {
+			return err
+		}
+
+		return s.createLog(tx, record.ID, constants.PaymentEventRefundRequested, map[string]interface{}{
+			"refund_id": refund.ID,
+			"amount":    refund.Money().Decimal(),
+			"currency":  refund.Currency,
+			"manual":    refund.Manual,
+			"reason":    refund.Reason,
+			"admin_id":  req.AdminID,
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reference := ""
+	if refunder != nil {
+		result, err := s.refundAtGateway(refunder, &record, &refund)
+		if err == nil && result.Status == constants.RefundStatusFailed {
+			err = ErrRefundDeclined
+		}
+		if err != nil {
+			s.failRefund(&refund, err)
+			return &refund, &record, errors.NewPaymentGatewayError(record.Gateway, err)
+		}
+
+		if result.Status == constants.RefundStatusPending {
+			if result.Reference != "" {
+				if err := s.db.Model(&refund).Update("gateway_reference", result.Reference).Error; err != nil {
+					return nil, nil, err
+				}
+			}
+			return &refund, &record, nil
+		}
+		reference = result.Reference
+	}
+
+	updated, err := s.settleRefund(refund.ID, reference)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.db.First(&refund, refund.ID).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return &refund, updated, nil
+}
+
+// RecordGatewayRefund applies a refund the gateway reported. Refunds requested through
+// Refund are matched by reference and settled once; anything else was issued from the
+// gateway's own dashboard and is recorded as a new refund.
+func (s *PaymentService) RecordGatewayRefund(paymentID uint, notice payment.RefundNotice, data map[string]interface{}) (*models.Payment, bool, error) {
+	logData := map[string]interface{}{
+		"source":           constants.PaymentSourceGateway,
+		"refund_reference": notice.Reference,
+	}
+	for key, value := range data {
+		logData[key] = value
+	}
+
+	return s.transition(paymentID, logData, func(tx *gorm.DB, record *models.Payment) (string, error) {
+		remaining := record.AmountMinor - record.RefundedAmountMinor
+		amount := notice.Amount.Amount
+		if notice.Amount.Currency != record.Currency || amount > remaining {
+			amount = remaining
+		}
+
+		var refund models.Refund
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("payment_id = ? AND gateway_reference = ?", record.ID, notice.Reference).
+			First(&refund).Error
+		if stderrors.Is(err, gorm.ErrRecordNotFound) {
+			// The notification can beat the refund response we store the reference from
+			err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("payment_id = ? AND status = ? AND gateway_reference IS NULL AND manual = ? AND amount_minor = ?",
+					record.ID, constants.RefundStatusPending, false, amount).
+				Order("id").
+				First(&refund).Error
+		}
+
+		switch {
+		case err == nil:
+			if refund.Status == constants.RefundStatusCompleted {
+				return "", nil
+			}
+		case stderrors.Is(err, gorm.ErrRecordNotFound):
+			refund = models.Refund{
+				PaymentID:   record.ID,
+				AmountMinor: amount,
+				Currency:    record.Currency,
+				Status:      constants.RefundStatusPending,
+				Reason:      "issued at gateway",
+			}
+			if err := tx.Create(&refund).Error; err != nil {
+				return "", err
+			}
+		default:
+			return "", err
+		}
+
+		return completeRefund(tx, record, &refund, notice.Reference)
+	})
+}
+
+func (s *PaymentService) refundAtGateway(refunder payment.Refunder, record *models.Payment, refund *models.Refund) (*payment.RefundResult, error) {
+	full := refund.AmountMinor == record.AmountMinor
+	amount := refund.Money()
+	if record.ChargedCurrency != record.Currency {
+		amount = record.ChargedMoney()
+	}
+
+	params := payment.RefundParams{
+		Amount: amount,
+		Full:   full,
+		Reason: refund.Reason,
+	}
+	if record.GatewayReference != nil {
+		params.Reference = *record.GatewayReference
+	}
+	if record.GatewayTransactionID != nil {
+		params.TransactionID = *record.GatewayTransactionID
+	}
+
+	return refunder.RefundPayment(params)
+}
+
+// settleRefund completes a pending refund and moves the payment to refunded or
+// partially_refunded.
+func (s *PaymentService) settleRefund(refundID uint, reference string) (*models.Payment, error) {
+	var refund models.Refund
+	if err := s.db.First(&refund, refundID).Error; err != nil {
+		return nil, err
+	}
+
+	updated, _, err := s.transition(refund.PaymentID, map[string]interface{}{
+		"source":    constants.PaymentSourceRefund,
+		"refund_id": refund.ID,
+	}, func(tx *gorm.DB, record *models.Payment) (string, error) {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&refund, refundID).Error; err != nil {
+			return "", err
+		}
+		// Already settled by a gateway notification
+		if refund.Status != constants.RefundStatusPending {
+			return "", nil
+		}
+		return completeRefund(tx, record, &refund, reference)
+	})
+	return updated, err
+}
+
+func (s *PaymentService) failRefund(refund *models.Refund, cause error) {
+	refund.Status = constants.RefundStatusFailed
+	if err := s.db.Model(refund).Update("status", refund.Status).Error; err != nil {
+		log.Printf("Failed to mark refund %d as failed: %v", refund.ID, err)
+	}
+
+	if err := s.CreateLog(refund.PaymentID, constants.PaymentEventRefundFailed, map[string]interface{}{
+		"refund_id": refund.ID,
+		"error":     cause.Error(),
+	}); err != nil {
+		log.Printf("Failed to log refund %d failure: %v", refund.ID, err)
+	}
+}
+
+func completeRefund(tx *gorm.DB, record *models.Payment, refund *models.Refund, reference string) (string, error) {
+	refund.Status = constants.RefundStatusCompleted
+	if reference != "" {
+		refund.GatewayReference = &reference
+	}
+	if err := tx.Save(refund).Error; err != nil {
+		return "", err
+	}
+
+	record.RefundedAmountMinor += refund.AmountMinor
+	if record.RefundedAmountMinor >= record.AmountMinor {
+		return constants.PaymentStatusRefunded, nil
+	}
+	return constants.PaymentStatusPartiallyRefunded, nil
+}
@@ -0,0 +1,319 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
+	"vinak/pkg/payment"
+
+	"gorm.io/gorm"
+)
+
+func countRefunds(t *testing.T, db *gorm.DB, paymentID uint) int64 {
+	t.Helper()
+
+	var count int64
+	if err := db.Model(&models.Refund{}).Where("payment_id = ?", paymentID).Count(&count).Error; err != nil {
+		t.Fatalf("failed to count refunds: %v", err)
+	}
+	return count
+}
+
+func lastPaymentLog(t *testing.T, db *gorm.DB, paymentID uint) models.PaymentLog {
+	t.Helper()
+
+	var entry models.PaymentLog
+	if err := db.Where("payment_id = ?", paymentID).Order("id DESC").First(&entry).Error; err != nil {
+		t.Fatalf("failed to find payment log: %v", err)
+	}
+	return entry
+}
+
+func TestRecordGatewayRefundRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   string
+		refunded int64
+	}{
+		{"pending", constants.PaymentStatusPending, 0},
+		{"failed", constants.PaymentStatusFailed, 0},
+		{"already refunded", constants.PaymentStatusRefunded, 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			service := NewPaymentService(db, payment.NewRegistry())
+			user := createTestUser(t, db, "donor")
+			record := createTestPayment(t, db, models.Payment{
+				UserID:              user.ID,
+				AmountMinor:         1000,
+				RefundedAmountMinor: tt.refunded,
+				Currency:            constants.CurrencyUSD,
+				Status:              tt.status,
+				Gateway:             constants.PaymentGatewayPayPal,
+			})
+
+			updated, changed, err := service.RecordGatewayRefund(record.ID, payment.RefundNotice{
+				Reference: "REFUND-1",
+				Amount:    money.New(1000, constants.CurrencyUSD),
+			}, nil)
+			if err != nil {
+				t.Fatalf("RecordGatewayRefund() error = %v", err)
+			}
+			if changed {
+				t.Errorf("RecordGatewayRefund() changed a %s payment", tt.status)
+			}
+			if updated.Status != tt.status || updated.RefundedAmountMinor != tt.refunded {
+				t.Errorf("returned payment is %s with %d refunded, want %s with %d", updated.Status, updated.RefundedAmountMinor, tt.status, tt.refunded)
+			}
+
+			var stored models.Payment
+			if err := db.First(&stored, record.ID).Error; err != nil {
+				t.Fatalf("failed to reload payment: %v", err)
+			}
+			if stored.Status != tt.status || stored.RefundedAmountMinor != tt.refunded {
+				t.Errorf("stored payment is %s with %d refunded, want %s with %d", stored.Status, stored.RefundedAmountMinor, tt.status, tt.refunded)
+			}
+			if count := countRefunds(t, db, record.ID); count != 0 {
+				t.Errorf("%d refunds were recorded, want none", count)
+			}
+			if entry := lastPaymentLog(t, db, record.ID); entry.Event != constants.PaymentEventNotificationReceived {
+				t.Errorf("last log event = %q, want %q", entry.Event, constants.PaymentEventNotificationReceived)
+			}
+		})
+	}
+}
+
+func TestRecordGatewayRefundIssuedAtGateway(t *testing.T) {
+	db := newTestDB(t)
+	service := NewPaymentService(db, payment.NewRegistry())
+	user := createTestUser(t, db, "donor")
+	record := createTestPayment(t, db, models.Payment{
+		UserID:      user.ID,
+		AmountMinor: 1000,
+		Currency:    constants.CurrencyUSD,
+		Status:      constants.PaymentStatusCompleted,
+		Gateway:     constants.PaymentGatewayPayPal,
+	})
+
+	var transitions []Transition
+	service.OnTransition(func(transition Transition) {
+		transitions = append(transitions, transition)
+	})
+
+	notice := payment.RefundNotice{Reference: "REFUND-1", Amount: money.New(400, constants.CurrencyUSD)}
+	updated, changed, err := service.RecordGatewayRefund(record.ID, notice, nil)
+	if err != nil {
+		t.Fatalf("RecordGatewayRefund() error = %v", err)
+	}
+	if !changed || updated.Status != constants.PaymentStatusPartiallyRefunded || updated.RefundedAmountMinor != 400 {
+		t.Errorf("RecordGatewayRefund() = %s with %d refunded (changed %v), want partially_refunded with 400", updated.Status, updated.RefundedAmountMinor, changed)
+	}
+
+	// A replayed notice is matched by its reference and not counted twice
+	updated, changed, err = service.RecordGatewayRefund(record.ID, notice, nil)
+	if err != nil {
+		t.Fatalf("RecordGatewayRefund() replay error = %v", err)
+	}
+	if changed || updated.RefundedAmountMinor != 400 {
+		t.Errorf("replayed notice changed the payment to %d refunded", updated.RefundedAmountMinor)
+	}
+	if count := countRefunds(t, db, record.ID); count != 1 {
+		t.Errorf("%d refunds were recorded, want 1", count)
+	}
+	if len(transitions) != 1 || transitions[0].To != constants.PaymentStatusPartiallyRefunded {
+		t.Errorf("hooks saw %+v, want a single move to partially_refunded", transitions)
+	}
+}
+
+// testGateway is a gateway without refunds, like NowPayments
+type testGateway struct {
+	name string
+}
+
+func (g *testGateway) Name() string {
+	return g.name
+}
+
+func (g *testGateway) SupportedCurrencies() []string {
+	return []string{constants.CurrencyUSD}
+}
+
+func (g *testGateway) CreatePayment(params payment.CreateParams) (*payment.CreateResult, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (g *testGateway) VerifyPayment(params payment.VerifyParams) (*payment.VerifyResult, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (g *testGateway) GetPaymentStatus(reference string) (*payment.StatusResult, error) {
+	return nil, errors.New("not implemented")
+}
+
+// refundingGateway answers refunds with a fixed result and records what it was asked
+type refundingGateway struct {
+	testGateway
+	result   *payment.RefundResult
+	err      error
+	requests []payment.RefundParams
+}
+
+func (g *refundingGateway) RefundPayment(params payment.RefundParams) (*payment.RefundResult, error) {
+	g.requests = append(g.requests, params)
+	return g.result, g.err
+}
+
+func createRefundablePayment(t *testing.T, db *gorm.DB, gateway string) *models.Payment {
+	t.Helper()
+
+	user := createTestUser(t, db, "donor")
+	transactionID := "CAPTURE-1"
+	return createTestPayment(t, db, models.Payment{
+		UserID:               user.ID,
+		AmountMinor:          1000,
+		Currency:             constants.CurrencyUSD,
+		Status:               constants.PaymentStatusCompleted,
+		Gateway:              gateway,
+		GatewayTransactionID: &transactionID,
+	})
+}
+
+func TestRefundAtGateway(t *testing.T) {
+	tests := []struct {
+		name          string
+		amount        int64
+		result        *payment.RefundResult
+		err           error
+		wantErr       bool
+		wantRefund    string
+		wantPayment   string
+		wantRefunded  int64
+		wantReference string
+	}{
+		{
+			name: "full refund", result: &payment.RefundResult{Reference: "REFUND-1", Status: constants.RefundStatusCompleted},
+			wantRefund: constants.RefundStatusCompleted, wantPayment: constants.PaymentStatusRefunded, wantRefunded: 1000, wantReference: "REFUND-1",
+		},
+		{
+			name: "partial refund", amount: 400, result: &payment.RefundResult{Reference: "REFUND-1", Status: constants.RefundStatusCompleted},
+			wantRefund: constants.RefundStatusCompleted, wantPayment: constants.PaymentStatusPartiallyRefunded, wantRefunded: 400, wantReference: "REFUND-1",
+		},
+		{
+			name: "settled later", result: &payment.RefundResult{Reference: "REFUND-1", Status: constants.RefundStatusPending},
+			wantRefund: constants.RefundStatusPending, wantPayment: constants.PaymentStatusCompleted, wantReference: "REFUND-1",
+		},
+		{
+			name: "declined", result: &payment.RefundResult{Status: constants.RefundStatusFailed}, wantErr: true,
+			wantRefund: constants.RefundStatusFailed, wantPayment: constants.PaymentStatusCompleted,
+		},
+		{
+			name: "gateway error", err: errors.New("i/o timeout"), wantErr: true,
+			wantRefund: constants.RefundStatusFailed, wantPayment: constants.PaymentStatusCompleted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			gateway := &refundingGateway{testGateway: testGateway{name: constants.PaymentGatewayPayPal}, result: tt.result, err: tt.err}
+			service := NewPaymentService(db, payment.NewRegistry(gateway))
+			record := createRefundablePayment(t, db, gateway.Name())
+
+			req := RefundRequest{PaymentID: record.ID, Reason: "duplicate", AdminID: 1}
+			if tt.amount != 0 {
+				amount := money.New(tt.amount, constants.CurrencyUSD)
+				req.Amount = &amount
+			}
+			refund, _, err := service.Refund(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Refund() error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(gateway.requests) != 1 || gateway.requests[0].TransactionID != "CAPTURE-1" {
+				t.Fatalf("gateway was asked for %+v, want one refund of CAPTURE-1", gateway.requests)
+			}
+
+			var stored models.Refund
+			if err := db.First(&stored, refund.ID).Error; err != nil {
+				t.Fatalf("failed to reload refund: %v", err)
+			}
+			if stored.Status != tt.wantRefund {
+				t.Errorf("refund status = %s, want %s", stored.Status, tt.wantRefund)
+			}
+			reference := ""
+			if stored.GatewayReference != nil {
+				reference = *stored.GatewayReference
+			}
+			if reference != tt.wantReference {
+				t.Errorf("refund reference = %q, want %q", reference, tt.wantReference)
+			}
+
+			var updated models.Payment
+			if err := db.First(&updated, record.ID).Error; err != nil {
+				t.Fatalf("failed to reload payment: %v", err)
+			}
+			if updated.Status != tt.wantPayment || updated.RefundedAmountMinor != tt.wantRefunded {
+				t.Errorf("payment is %s with %d refunded, want %s with %d", updated.Status, updated.RefundedAmountMinor, tt.wantPayment, tt.wantRefunded)
+			}
+		})
+	}
+}
+
+func TestRefundSettledByNotification(t *testing.T) {
+	db := newTestDB(t)
+	gateway := &refundingGateway{
+		testGateway: testGateway{name: constants.PaymentGatewayPayPal},
+		result:      &payment.RefundResult{Reference: "REFUND-1", Status: constants.RefundStatusPending},
+	}
+	service := NewPaymentService(db, payment.NewRegistry(gateway))
+	record := createRefundablePayment(t, db, gateway.Name())
+
+	if _, _, err := service.Refund(RefundRequest{PaymentID: record.ID, AdminID: 1}); err != nil {
+		t.Fatalf("Refund() error = %v", err)
+	}
+
+	// The pending refund holds the balance until the gateway settles it
+	if _, _, err := service.Refund(RefundRequest{PaymentID: record.ID, Manual: true, AdminID: 1}); !errors.Is(err, ErrRefundExceedsBalance) {
+		t.Errorf("second Refund() error = %v, want %v", err, ErrRefundExceedsBalance)
+	}
+
+	updated, changed, err := service.RecordGatewayRefund(record.ID, payment.RefundNotice{
+		Reference: "REFUND-1",
+		Amount:    money.New(1000, constants.CurrencyUSD),
+	}, nil)
+	if err != nil {
+		t.Fatalf("RecordGatewayRefund() error = %v", err)
+	}
+	if !changed || updated.Status != constants.PaymentStatusRefunded {
+		t.Errorf("RecordGatewayRefund() = %s (changed %v), want refunded", updated.Status, changed)
+	}
+	if count := countRefunds(t, db, record.ID); count != 1 {
+		t.Errorf("%d refunds were recorded, want the requested one settled", count)
+	}
+}
+
+func TestRefundWithoutRefunder(t *testing.T) {
+	db := newTestDB(t)
+	service := NewPaymentService(db, payment.NewRegistry(&testGateway{name: constants.PaymentGatewayNowPayments}))
+	record := createRefundablePayment(t, db, constants.PaymentGatewayNowPayments)
+
+	var unsupported *payment.UnsupportedRefundError
+	if _, _, err := service.Refund(RefundRequest{PaymentID: record.ID, AdminID: 1}); !errors.As(err, &unsupported) {
+		t.Fatalf("Refund() error = %v, want an UnsupportedRefundError", err)
+	}
+	if count := countRefunds(t, db, record.ID); count != 0 {
+		t.Errorf("%d refunds were recorded for an unsupported gateway, want none", count)
+	}
+
+	// Returned by hand instead
+	refund, updated, err := service.Refund(RefundRequest{PaymentID: record.ID, Manual: true, AdminID: 1})
+	if err != nil {
+		t.Fatalf("manual Refund() error = %v", err)
+	}
+	if !refund.Manual || refund.Status != constants.RefundStatusCompleted || updated.Status != constants.PaymentStatusRefunded {
+		t.Errorf("manual refund is %s (manual %v) and payment %s, want a completed manual refund of a refunded payment", refund.Status, refund.Manual, updated.Status)
+	}
+}
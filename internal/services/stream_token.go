@@ -0,0 +1,79 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidStreamToken = stderrors.New("stream token is invalid or expired")
+
+// StreamToken lets a browser subscribe to one payment's events until it expires
+type StreamToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// StreamTokenService issues the tokens payment event streams are opened with. EventSource
+// can't send headers, so the credential ends up in the URL and with it in access logs; a
+// token that is only good for one payment and a few minutes keeps the API key out of it.
+type StreamTokenService struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewStreamTokenService(secret string, ttl time.Duration) *StreamTokenService {
+	return &StreamTokenService{
+		secret: []byte(secret),
+		ttl:    ttl,
+	}
+}
+
+// Issue returns a token for userID to follow paymentID
+func (s *StreamTokenService) Issue(paymentID, userID uint) StreamToken {
+	expiresAt := time.Now().Add(s.ttl).Truncate(time.Second)
+	payload := fmt.Sprintf("%d.%d.%d", paymentID, userID, expiresAt.Unix())
+	return StreamToken{
+		Token:     payload + "." + s.signature(payload),
+		ExpiresAt: expiresAt,
+	}
+}
+
+// Verify checks a token and returns the payment and user it was issued for
+func (s *StreamTokenService) Verify(token string) (uint, uint, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return 0, 0, ErrInvalidStreamToken
+	}
+
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(s.signature(payload)), []byte(parts[3])) {
+		return 0, 0, ErrInvalidStreamToken
+	}
+
+	paymentID, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, ErrInvalidStreamToken
+	}
+	userID, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, ErrInvalidStreamToken
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return 0, 0, ErrInvalidStreamToken
+	}
+
+	return uint(paymentID), uint(userID), nil
+}
+
+func (s *StreamTokenService) signature(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
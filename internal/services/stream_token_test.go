@@ -0,0 +1,48 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamTokenRoundTrip(t *testing.T) {
+	service := NewStreamTokenService("secret", time.Minute)
+	token := service.Issue(7, 42)
+
+	paymentID, userID, err := service.Verify(token.Token)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if paymentID != 7 || userID != 42 {
+		t.Errorf("Verify() = (%d, %d), want (7, 42)", paymentID, userID)
+	}
+
+	parts := strings.Split(token.Token, ".")
+	signature := parts[3]
+
+	tests := []struct {
+		name    string
+		service *StreamTokenService
+		token   string
+	}{
+		{"other payment", service, strings.Join([]string{"8", parts[1], parts[2], signature}, ".")},
+		{"other user", service, strings.Join([]string{parts[0], "43", parts[2], signature}, ".")},
+		{"extended expiry", service, strings.Join([]string{parts[0], parts[1], parts[2] + "0", signature}, ".")},
+		{"altered signature", service, token.Token[:len(token.Token)-1] + "x"},
+		{"missing signature", service, strings.Join(parts[:3], ".")},
+		{"extra part", service, token.Token + ".1"},
+		{"empty", service, ""},
+		{"other secret", NewStreamTokenService("other", time.Minute), token.Token},
+		{"expired", NewStreamTokenService("secret", -time.Minute), NewStreamTokenService("secret", -time.Minute).Issue(7, 42).Token},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := tt.service.Verify(tt.token); !errors.Is(err, ErrInvalidStreamToken) {
+				t.Errorf("Verify(%q) error = %v, want %v", tt.token, err, ErrInvalidStreamToken)
+			}
+		})
+	}
+}
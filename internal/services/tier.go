@@ -0,0 +1,139 @@
+package services
+
+import (
+	"log"
+	"vinak/internal/models"
+	"vinak/pkg/constants"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TierService awards supporter tiers from donors' cumulative donations.
+type TierService struct {
+	db *gorm.DB
+}
+
+func NewTierService(db *gorm.DB) *TierService {
+	return &TierService{
+		db: db,
+	}
+}
+
+// HandleTransition awards any tier a completed payment took the credited user into
+func (s *TierService) HandleTransition(transition Transition) {
+	userID := transition.Payment.CreditedUserID
+	if transition.To != constants.PaymentStatusCompleted || userID == nil {
+		return
+	}
+	if err := s.Assign(*userID); err != nil {
+		log.Printf("Failed to assign tiers to user %d: %v", *userID, err)
+	}
+}
+
+// Totals returns a user's tier credit per currency in minor units: what they gave less
+// refunds, plus any promo code bonus
+func (s *TierService) Totals(userID uint) (map[string]int64, error) {
+	totals, err := s.totals(userID)
+	if err != nil {
+		return nil, err
+	}
+	return totals[userID], nil
+}
+
+// totals sums counted payments per credited user and currency, for one user or every
+// user if userID is zero. A promo code bonus shrinks in proportion to what was refunded.
+func (s *TierService) totals(userID uint) (map[uint]map[string]int64, error) {
+	db := s.db.Model(&models.Payment{}).
+		Select("credited_user_id AS user_id, currency, SUM(amount_minor - refunded_amount_minor + "+
+			"tier_bonus_minor * (amount_minor - refunded_amount_minor) / GREATEST(amount_minor, 1)) AS total").
+		Where("status IN ? AND credited_user_id IS NOT NULL", models.CountedPaymentStatuses)
+	if userID != 0 {
+		db = db.Where("credited_user_id = ?", userID)
+	}
+
+	var rows []struct {
+		UserID   uint
+		Currency string
+		Total    int64
+	}
+	if err := db.Group("credited_user_id, currency").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	totals := make(map[uint]map[string]int64)
+	for _, row := range rows {
+		if totals[row.UserID] == nil {
+			totals[row.UserID] = make(map[string]int64)
+		}
+		totals[row.UserID][row.Currency] = row.Total
+	}
+	return totals, nil
+}
+
+// Assign awards a user every tier their totals reach that they don't have yet
+func (s *TierService) Assign(userID uint) error {
+	totals, err := s.totals(userID)
+	if err != nil {
+		return err
+	}
+	return s.assign(totals)
+}
+
+// AssignAll awards tiers to every donor, for when tiers are added or lowered
+func (s *TierService) AssignAll() error {
+	totals, err := s.totals(0)
+	if err != nil {
+		return err
+	}
+	return s.assign(totals)
+}
+
+func (s *TierService) assign(totals map[uint]map[string]int64) error {
+	var tiers []models.Tier
+	if err := s.db.Find(&tiers).Error; err != nil {
+		return err
+	}
+
+	var awards []models.TierAward
+	for userID, userTotals := range totals {
+		for _, tier := range tiers {
+			if tier.ReachedBy(userTotals) {
+				awards = append(awards, models.TierAward{
+					UserID:           userID,
+					TierID:           tier.ID,
+					FulfilmentStatus: constants.FulfilmentStatusPending,
+				})
+			}
+		}
+	}
+	if len(awards) == 0 {
+		return nil
+	}
+
+	return s.db.Omit("Tier").
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "user_id"}, {Name: "tier_id"}}, DoNothing: true}).
+		CreateInBatches(&awards, 500).Error
+}
+
+// Awards returns a user's tiers from highest to lowest
+func (s *TierService) Awards(userID uint) ([]models.TierAward, error) {
+	var awards []models.TierAward
+	err := s.db.Joins("Tier").
+		Where("tier_awards.user_id = ?", userID).
+		Order(`"Tier".level DESC`).
+		Find(&awards).Error
+	return awards, err
+}
+
+// NextTier returns the lowest tier above level, nil if there is none
+func (s *TierService) NextTier(level int) (*models.Tier, error) {
+	var tiers []models.Tier
+	if err := s.db.Where("level > ?", level).Order("level").Limit(1).Find(&tiers).Error; err != nil {
+		return nil, err
+	}
+	if len(tiers) == 0 {
+		return nil, nil
+	}
+	return &tiers[0], nil
+}
@@ -0,0 +1,67 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+	"vinak/internal/models"
+	"vinak/internal/services"
+	"vinak/pkg/constants"
+
+	"gorm.io/gorm"
+)
+
+const expireBatchSize = 50
+
+// Expirer marks payments that stayed open longer than their gateway's window as expired.
+type Expirer struct {
+	db             *gorm.DB
+	paymentService *services.PaymentService
+	interval       time.Duration
+	windows        map[string]time.Duration
+}
+
+func NewExpirer(db *gorm.DB, paymentService *services.PaymentService, interval time.Duration, windows map[string]time.Duration) *Expirer {
+	return &Expirer{
+		db:             db,
+		paymentService: paymentService,
+		interval:       interval,
+		windows:        windows,
+	}
+}
+
+func (e *Expirer) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for gateway, window := range e.windows {
+				e.expire(gateway, window)
+			}
+		}
+	}
+}
+
+func (e *Expirer) expire(gateway string, window time.Duration) {
+	var records []models.Payment
+	if err := e.db.
+		Where("gateway = ?", gateway).
+		Where("status IN ?", []string{constants.PaymentStatusPending, constants.PaymentStatusAwaitingConfirmation}).
+		Where("created_at < ?", time.Now().Add(-window)).
+		Order("created_at ASC").
+		Limit(expireBatchSize).
+		Find(&records).Error; err != nil {
+		log.Printf("Failed to load %s payments to expire: %v", gateway, err)
+		return
+	}
+
+	for i := range records {
+		if _, err := e.paymentService.Expire(&records[i]); err != nil {
+			log.Printf("Failed to expire payment %d: %v", records[i].ID, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+	"vinak/internal/models"
+	"vinak/internal/services"
+	"vinak/pkg/rates"
+
+	"gorm.io/gorm"
+)
+
+// RateRefresher periodically stores the provider's exchange rates against the reference
+// currency. A rate equal to the latest stored one isn't stored again, as that row stays
+// in effect until a different rate arrives. Donations convert at the rate in effect when
+// they were made, so a new rate only changes earlier ones when it is the currency's first:
+// until then they were left out of the combined leaderboards, which are rebuilt.
+type RateRefresher struct {
+	db          *gorm.DB
+	provider    rates.Provider
+	leaderboard *services.LeaderboardService
+	reference   string
+	currencies  []string
+	interval    time.Duration
+}
+
+func NewRateRefresher(db *gorm.DB, provider rates.Provider, leaderboard *services.LeaderboardService, reference string, currencies []string, interval time.Duration) *RateRefresher {
+	return &RateRefresher{
+		db:          db,
+		provider:    provider,
+		leaderboard: leaderboard,
+		reference:   reference,
+		currencies:  currencies,
+		interval:    interval,
+	}
+}
+
+// Run refreshes straight away, so a fresh deployment has rates, and then every interval
+func (r *RateRefresher) Run(ctx context.Context) {
+	r.refresh()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.refresh()
+		}
+	}
+}
+
+func (r *RateRefresher) refresh() {
+	var currencies []string
+	for _, currency := range r.currencies {
+		if currency != r.reference {
+			currencies = append(currencies, currency)
+		}
+	}
+
+	fetched, err := r.provider.Rates(r.reference, currencies)
+	if err != nil {
+		log.Printf("Failed to fetch exchange rates from %s: %v", r.provider.Name(), err)
+		return
+	}
+
+	now := time.Now()
+	firstRate := false
+	for _, currency := range currencies {
+		rate, ok := fetched[currency]
+		if !ok {
+			log.Printf("No %s exchange rate from %s", currency, r.provider.Name())
+			continue
+		}
+
+		var latest models.ExchangeRate
+		err := r.db.Where("currency = ? AND reference_currency = ?", currency, r.reference).
+			Order("fetched_at DESC").
+			Limit(1).
+			Find(&latest).Error
+		if err != nil {
+			log.Printf("Failed to load the latest %s exchange rate: %v", currency, err)
+			continue
+		}
+		if latest.ID != 0 && latest.Rate == rate {
+			continue
+		}
+
+		if err := r.db.Create(&models.ExchangeRate{
+			Currency:          currency,
+			ReferenceCurrency: r.reference,
+			Rate:              rate,
+			Source:            r.provider.Name(),
+			FetchedAt:         now,
+		}).Error; err != nil {
+			log.Printf("Failed to store the %s exchange rate: %v", currency, err)
+			continue
+		}
+		// Donations made before the first rate convert at it
+		if latest.ID == 0 {
+			firstRate = true
+		}
+	}
+
+	if firstRate {
+		if err := r.leaderboard.RebuildCombined(); err != nil {
+			log.Printf("Failed to rebuild the combined leaderboard: %v", err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+	"vinak/internal/models"
+	"vinak/internal/services"
+	"vinak/pkg/constants"
+
+	"gorm.io/gorm"
+)
+
+const reconcileBatchSize = 50
+
+// Reconciler periodically asks the gateways about payments whose callbacks never arrived.
+type Reconciler struct {
+	db             *gorm.DB
+	paymentService *services.PaymentService
+	interval       time.Duration
+	minAge         time.Duration
+}
+
+func NewReconciler(db *gorm.DB, paymentService *services.PaymentService, interval, minAge time.Duration) *Reconciler {
+	return &Reconciler{
+		db:             db,
+		paymentService: paymentService,
+		interval:       interval,
+		minAge:         minAge,
+	}
+}
+
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reconcile()
+		}
+	}
+}
+
+func (r *Reconciler) reconcile() {
+	cutoff := time.Now().Add(-r.minAge)
+
+	// Least recently checked first, so a few unreachable payments can't starve the rest
+	var records []models.Payment
+	if err := r.db.
+		Where("status IN ?", []string{constants.PaymentStatusPending, constants.PaymentStatusAwaitingConfirmation}).
+		Where("gateway_reference IS NOT NULL").
+		Where("created_at < ?", cutoff).
+		Where("last_checked_at IS NULL OR last_checked_at < ?", time.Now().Add(-r.interval)).
+		Order("last_checked_at ASC NULLS FIRST").
+		Limit(reconcileBatchSize).
+		Find(&records).Error; err != nil {
+		log.Printf("Failed to load payments to reconcile: %v", err)
+		return
+	}
+
+	for i := range records {
+		if _, _, err := r.paymentService.Sync(&records[i]); err != nil {
+			log.Printf("Failed to reconcile payment %d: %v", records[i].ID, err)
+		}
+	}
+}
@@ -2,9 +2,51 @@ package constants
 
 const (
 	// Payment Statuses
-	PaymentStatusPending   = "pending"
-	PaymentStatusCompleted = "completed"
-	PaymentStatusFailed    = "failed"
+	PaymentStatusPending              = "pending"
+	PaymentStatusAwaitingConfirmation = "awaiting_confirmation"
+	PaymentStatusCompleted            = "completed"
+	PaymentStatusFailed               = "failed"
+	PaymentStatusExpired              = "expired"
+	PaymentStatusRefunded             = "refunded"
+	PaymentStatusPartiallyRefunded    = "partially_refunded"
+
+	// Refund Statuses
+	RefundStatusPending   = "pending"
+	RefundStatusCompleted = "completed"
+	RefundStatusFailed    = "failed"
+
+	// Donation Message Statuses
+	MessageStatusPending  = "pending"
+	MessageStatusApproved = "approved"
+	MessageStatusRejected = "rejected"
+
+	// Donor Privacy Settings
+	PrivacyPublic    = "public"
+	PrivacyNameOnly  = "name_only"
+	PrivacyAnonymous = "anonymous"
+
+	// AnonymousDonorName is shown in place of donors who asked not to be named
+	AnonymousDonorName = "Anonymous"
+
+	// Leaderboard Periods
+	LeaderboardPeriodAll    = "all"
+	LeaderboardPeriodMonth  = "month"
+	LeaderboardPeriodWeek   = "week"
+	LeaderboardPeriodCustom = "custom"
+
+	// Campaign Statuses
+	CampaignStatusDraft  = "draft"
+	CampaignStatusActive = "active"
+	CampaignStatusClosed = "closed"
+
+	// Reward Fulfilment Statuses
+	FulfilmentStatusPending   = "pending"
+	FulfilmentStatusFulfilled = "fulfilled"
+	FulfilmentStatusCancelled = "cancelled"
+
+	// Asset Stores
+	AssetStoreLocal = "local"
+	AssetStoreS3    = "s3"
 
 	// Payment Gateways
 	PaymentGatewayZarinpal    = "zarinpal"
@@ -14,39 +56,158 @@ const (
 	// Currencies
 	CurrencyUSD = "usd"
 	CurrencyIRR = "irr"
+	// CurrencyIRT is the Toman, 10 Rials, which Iranians usually quote prices in
+	CurrencyIRT = "irt"
 	CurrencyBTC = "btc"
 
+	// Exchange Rate Providers
+	RateProviderStatic = "static"
+	RateProviderHTTP   = "http"
+
 	// Payment Events
-	PaymentEventZarinpalCreated      = "zarinpal_payment_created"
-	PaymentEventPayPalOrderCreated   = "paypal_order_created"
-	PaymentEventNowPaymentsCreated   = "nowpayments_payment_created"
-	PaymentEventPayPalCaptured       = "paypal_payment_captured"
-	PaymentEventNowPaymentsCompleted = "nowpayments_payment_completed"
+	PaymentEventCreated                = "payment_created"
+	PaymentEventStatusChanged          = "status_changed"
+	PaymentEventNotificationReceived   = "notification_received"
+	PaymentEventReconciliationMismatch = "reconciliation_mismatch"
+	PaymentEventRefundRequested        = "refund_requested"
+	PaymentEventRefundFailed           = "refund_failed"
+	PaymentEventMessageModerated       = "message_moderated"
+	PaymentEventPromoCodeRedeemed      = "promo_code_redeemed"
+	PaymentEventGiftClaimed            = "gift_claimed"
+
+	// Payment change sources
+	PaymentSourceReconciliation = "reconciliation"
+	PaymentSourceExpiry         = "expiry"
+	PaymentSourceRefund         = "refund"
+	PaymentSourceGateway        = "gateway"
+	PaymentSourceCreation       = "creation"
 
 	// API Headers
-	HeaderAPIKey = "Authorization"
+	HeaderAPIKey             = "Authorization"
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+	HeaderETag               = "ETag"
+	HeaderLastModified       = "Last-Modified"
+	HeaderIfNoneMatch        = "If-None-Match"
+	HeaderIfModifiedSince    = "If-Modified-Since"
+	QueryStreamToken         = "token"
+	QueryOverlayToken        = "token"
+	QueryDownloadUser        = "user"
+	QueryDownloadExpires     = "expires"
+	QueryDownloadSignature   = "signature"
 
 	// PayPal Constants
 	PayPalIntentCapture = "CAPTURE"
 	PayPalModeSandbox   = "sandbox"
 	PayPalModeLive      = "live"
 
+	// Zarinpal Constants
+	ZarinpalStatusOK  = "OK"
+	ZarinpalStatusNOK = "NOK"
+
 	// NowPayments Constants
 	NowPaymentsDefaultPayCurrency = "btc"
 
 	// Error Messages
 	ErrAPIKeyRequired        = "API key is required"
 	ErrInvalidAPIKey         = "Invalid API key"
+	ErrInvalidStreamToken    = "Stream token is invalid or has expired"
 	ErrInvalidPaymentGateway = "Invalid payment gateway"
-	ErrInvalidCurrency       = "Invalid currency. Must be 'usd' or 'irr'"
+	ErrInvalidCurrency       = "Invalid currency"
+	ErrInvalidAmount         = "Invalid amount for the selected currency"
 	ErrPaymentNotFound       = "Payment not found"
 	ErrUserNotFound          = "Failed to find user"
 	ErrFailedToCreatePayment = "Failed to create payment record"
 	ErrFailedToUpdatePayment = "Failed to update payment status"
 	ErrFailedToCreateLog     = "Failed to create payment log"
 	ErrFailedToGetTopUsers   = "Failed to get top users"
+	ErrInvalidSignature      = "Invalid signature"
+	ErrAdminRequired         = "Admin access required"
+
+	// Refunds
+	ErrInvalidPaymentID         = "Invalid payment ID"
+	ErrInvalidRefundAmount      = "Invalid refund amount"
+	ErrPaymentNotRefundable     = "Only completed payments can be refunded"
+	ErrRefundExceedsBalance     = "Refund amount exceeds the refundable balance"
+	ErrPartialRefundUnsupported = "Gateway only supports full refunds"
+	ErrFailedToRefundPayment    = "Failed to refund payment"
+
+	// Payment history
+	ErrInvalidStatus       = "Invalid payment status"
+	ErrInvalidCursor       = "Invalid cursor"
+	ErrInvalidLimit        = "Limit must be between 1 and 100"
+	ErrFailedToGetPayments = "Failed to get payments"
+	ErrFailedToSubscribe   = "Failed to subscribe to live events"
+
+	// Overlays
+	ErrOverlayTokenRequired = "Overlay token is required"
+	ErrInvalidOverlayToken  = "Invalid overlay token"
+	ErrOverlayNotFound      = "Overlay not found"
+	ErrFailedToGetOverlays  = "Failed to get overlays"
+	ErrFailedToSaveOverlay  = "Failed to save overlay"
+
+	// Donation messages
+	ErrMessageNotFound         = "Payment has no message"
+	ErrInvalidMessageStatus    = "Invalid message status"
+	ErrFailedToGetMessages     = "Failed to get messages"
+	ErrFailedToModerateMessage = "Failed to moderate message"
+
+	// Leaderboard
+	ErrInvalidPeriod    = "Period must be one of 'all', 'month', 'week' or 'custom'"
+	ErrInvalidDateRange = "Custom periods need 'from' and 'to' dates (YYYY-MM-DD or RFC 3339) with from before to"
+	ErrInvalidOffset    = "Offset must not be negative"
+
+	// Privacy
+	ErrInvalidPrivacy        = "Privacy must be one of 'public', 'name_only' or 'anonymous'"
+	ErrFailedToUpdatePrivacy = "Failed to update privacy setting"
+
+	// Campaigns
+	ErrCampaignNotFound      = "Campaign not found"
+	ErrCampaignNotActive     = "Campaign is not accepting donations"
+	ErrCampaignSlugTaken     = "A campaign with this slug already exists"
+	ErrInvalidCampaignSlug   = "Slug may only contain lowercase letters, digits and single dashes"
+	ErrInvalidCampaignStatus = "Status must be one of 'draft', 'active' or 'closed'"
+	ErrInvalidCampaignDates  = "Campaign must end after it starts"
+	ErrFailedToGetCampaigns  = "Failed to get campaigns"
+	ErrFailedToSaveCampaign  = "Failed to save campaign"
+
+	// Tiers
+	ErrTierNotFound            = "Tier not found"
+	ErrTierAwardNotFound       = "Tier award not found"
+	ErrTierLevelTaken          = "A tier with this level already exists"
+	ErrInvalidFulfilmentStatus = "Fulfilment status must be one of 'pending', 'fulfilled' or 'cancelled'"
+	ErrFailedToGetTiers        = "Failed to get tiers"
+	ErrFailedToSaveTier        = "Failed to save tier"
+
+	// Downloads
+	ErrDownloadNotAllowed   = "Downloads unlock with a completed donation"
+	ErrInvalidDownloadLink  = "Download link is invalid or has expired"
+	ErrDownloadLimitReached = "Download limit reached for this file"
+	ErrAssetNotFound        = "Asset not found"
+	ErrAssetFileMissing     = "Asset file not found in the store"
+	ErrFailedToGetDownloads = "Failed to get downloads"
+	ErrFailedToSaveAsset    = "Failed to save asset"
+
+	// Promo codes
+	ErrPromoCodeNotFound     = "Promo code not found"
+	ErrPromoCodeNotActive    = "Promo code is not active"
+	ErrPromoCodeUsedUp       = "Promo code has been used up"
+	ErrPromoCodeUserLimit    = "You have already used this promo code"
+	ErrPromoCodeTaken        = "Promo code already exists"
+	ErrPromoCodeInUse        = "Promo code has been redeemed, deactivate it instead"
+	ErrInvalidPromoCode      = "Promo code may only contain letters, digits, - and _"
+	ErrInvalidPromoCodeDates = "Promo code must expire after it starts"
+	ErrAmountBelowMinimum    = "Amount is below the minimum donation"
+	ErrFailedToGetPromoCodes = "Failed to get promo codes"
+	ErrFailedToSavePromoCode = "Failed to save promo code"
+
+	// Gifts
+	ErrInvalidRecipient         = "A gift recipient needs an email or Instagram handle"
+	ErrRecipientRequired        = "A recipient email is required for someone without an account"
+	ErrGiftToSelf               = "You can't send a gift to yourself"
+	ErrFailedToResolveRecipient = "Failed to find the gift recipient"
 
-	// Zarinpal Specific
-	ErrZarinpalOnlyIRR = "Zarinpal only supports IRR currency"
-	ErrPayPalOnlyUSD   = "PayPal only supports USD currency"
+	// Idempotency
+	ErrInvalidIdempotencyKey       = "Idempotency key must be at most 255 characters"
+	ErrFailedToCheckIdempotencyKey = "Failed to check idempotency key"
 )
@@ -9,7 +9,7 @@ import (
 	"gopkg.in/gomail.v2"
 )
 
-//go:embed template/otp.html
+//go:embed template/*.html
 var emailTemplates embed.FS
 
 type EmailService struct {
@@ -33,6 +33,17 @@ type EmailData struct {
 	ICON string
 }
 
+// GiftEmailData fills the email telling someone a donation was made in their name
+type GiftEmailData struct {
+	DonorName string
+	Amount    string
+	Message   string
+	// Claimed is true when the recipient already has an account the gift counts for
+	Claimed bool
+	URL     string
+	ICON    string
+}
+
 func (s *EmailService) SendVerificationEmail(to, otp string) error {
 	port, _ := strconv.Atoi(s.port)
 	d := gomail.NewDialer(s.host, port, s.username, s.password)
@@ -69,3 +80,30 @@ func (s *EmailService) SendVerificationEmail(to, otp string) error {
 
 	return d.DialAndSend(m)
 }
+
+func (s *EmailService) SendGiftNotification(to string, data GiftEmailData) error {
+	port, _ := strconv.Atoi(s.port)
+	d := gomail.NewDialer(s.host, port, s.username, s.password)
+
+	tmpl, err := template.ParseFS(emailTemplates, "template/gift.html")
+	if err != nil {
+		return err
+	}
+
+	if data.ICON == "" {
+		data.ICON = "https://ak47album.com/album-cover.jpg"
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return err
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.username)
+	m.SetHeader("To", to)
+	m.SetHeader("Subject", data.DonorName+" donated in your name")
+	m.SetBody("text/html", body.String())
+
+	return d.DialAndSend(m)
+}
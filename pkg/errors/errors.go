@@ -50,6 +50,10 @@ func (e *PaymentGatewayError) Error() string {
 	return fmt.Sprintf("payment gateway %s error: %v", e.Gateway, e.Err)
 }
 
+func (e *PaymentGatewayError) Unwrap() error {
+	return e.Err
+}
+
 // Validation Errors
 type ValidationError struct {
 	Field   string
@@ -0,0 +1,64 @@
+package moderation
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	FlagProfanity = "profanity"
+	FlagLink      = "link"
+)
+
+// defaultBlockedWords is extended through configuration
+var defaultBlockedWords = []string{
+	"fuck",
+	"shit",
+	"bitch",
+	"cunt",
+	"asshole",
+	"bastard",
+	"dick",
+	"whore",
+	"slut",
+}
+
+var linkPattern = regexp.MustCompile(`(?i)(https?://|www\.|\b[a-z0-9-]+\.(com|net|org|io|ir|me|ly|co|xyz|info|gg|tv)\b|t\.me/)`)
+
+// Filter flags donation messages that need a human look before being shown publicly.
+type Filter struct {
+	blocked *regexp.Regexp
+}
+
+func NewFilter(blockedWords []string) *Filter {
+	words := make([]string, 0, len(defaultBlockedWords)+len(blockedWords))
+	for _, word := range append(defaultBlockedWords, blockedWords...) {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" {
+			continue
+		}
+		// Prefix match so inflections ("fucking") are caught too. \b only knows ASCII
+		// word characters, so words in other scripts (e.g. Persian) match anywhere.
+		pattern := regexp.QuoteMeta(word)
+		if word[0] < 0x80 {
+			pattern = `\b` + pattern
+		}
+		words = append(words, pattern)
+	}
+
+	return &Filter{
+		blocked: regexp.MustCompile(`(?i)(` + strings.Join(words, "|") + `)`),
+	}
+}
+
+// Check returns the reasons message was flagged, nil if it is clean
+func (f *Filter) Check(message string) []string {
+	var flags []string
+	if f.blocked.MatchString(message) {
+		flags = append(flags, FlagProfanity)
+	}
+	if linkPattern.MatchString(message) {
+		flags = append(flags, FlagLink)
+	}
+	return flags
+}
@@ -0,0 +1,110 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"vinak/pkg/constants"
+)
+
+var (
+	ErrUnknownCurrency = errors.New("unknown currency")
+	ErrInvalidAmount   = errors.New("invalid amount")
+)
+
+// exponents is the number of minor units digits per currency
+var exponents = map[string]int{
+	constants.CurrencyUSD: 2,
+	constants.CurrencyIRR: 0,
+	constants.CurrencyIRT: 0,
+	constants.CurrencyBTC: 8,
+}
+
+// RialsPerToman is the fixed IRR/IRT ratio
+const RialsPerToman = 10
+
+// Money is an exact amount in the currency's minor units (cents, rials, satoshis).
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+func Exponent(currency string) (int, error) {
+	exponent, ok := exponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownCurrency, currency)
+	}
+	return exponent, nil
+}
+
+// Currencies lists every currency amounts can be stored in, sorted
+func Currencies() []string {
+	currencies := make([]string, 0, len(exponents))
+	for currency := range exponents {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+// Parse reads a plain decimal such as "12.5" without going through float64. Amounts with
+// more fraction digits than the currency has are rejected rather than rounded.
+func Parse(value, currency string) (Money, error) {
+	exponent, err := Exponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	digits := strings.TrimPrefix(value, "-")
+	whole, fraction, _ := strings.Cut(digits, ".")
+	if whole == "" || len(fraction) > exponent || strings.ContainsAny(whole+fraction, "+-eE") {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+
+	amount, err := strconv.ParseInt(whole+fraction+strings.Repeat("0", exponent-len(fraction)), 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+	if strings.HasPrefix(value, "-") {
+		amount = -amount
+	}
+
+	return New(amount, currency), nil
+}
+
+// String formats the amount as a plain decimal in major units, e.g. "12.50"
+func (m Money) String() string {
+	exponent := exponents[m.Currency]
+
+	sign, amount := "", m.Amount
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+
+	digits := strconv.FormatInt(amount, 10)
+	if exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+// Decimal returns the amount as a JSON number literal, so responses keep every digit
+func (m Money) Decimal() json.Number {
+	return json.Number(m.String())
+}
+
+// Format renders the amount for humans, e.g. "12.50 USD"
+func (m Money) Format() string {
+	return m.String() + " " + strings.ToUpper(m.Currency)
+}
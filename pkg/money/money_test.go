@@ -0,0 +1,143 @@
+package money
+
+import (
+	"errors"
+	"testing"
+	"vinak/pkg/constants"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency string
+		want     int64
+		err      error
+	}{
+		{"12.5", constants.CurrencyUSD, 1250, nil},
+		{"12.50", constants.CurrencyUSD, 1250, nil},
+		{"12", constants.CurrencyUSD, 1200, nil},
+		{"12.", constants.CurrencyUSD, 1200, nil},
+		{"0.01", constants.CurrencyUSD, 1, nil},
+		{"-3.25", constants.CurrencyUSD, -325, nil},
+		{"0.00000001", constants.CurrencyBTC, 1, nil},
+		{"1.5", constants.CurrencyBTC, 150000000, nil},
+		{"500000", constants.CurrencyIRR, 500000, nil},
+		{"12.505", constants.CurrencyUSD, 0, ErrInvalidAmount},
+		{"1.5", constants.CurrencyIRT, 0, ErrInvalidAmount},
+		{".5", constants.CurrencyUSD, 0, ErrInvalidAmount},
+		{"", constants.CurrencyUSD, 0, ErrInvalidAmount},
+		{"1e3", constants.CurrencyUSD, 0, ErrInvalidAmount},
+		{"+1", constants.CurrencyUSD, 0, ErrInvalidAmount},
+		{"--1", constants.CurrencyUSD, 0, ErrInvalidAmount},
+		{"1.-5", constants.CurrencyUSD, 0, ErrInvalidAmount},
+		{"abc", constants.CurrencyUSD, 0, ErrInvalidAmount},
+		{"99999999999999999999", constants.CurrencyUSD, 0, ErrInvalidAmount},
+		{"1", "eur", 0, ErrUnknownCurrency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value+" "+tt.currency, func(t *testing.T) {
+			got, err := Parse(tt.value, tt.currency)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Parse(%q, %q) error = %v, want %v", tt.value, tt.currency, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q, %q) error = %v", tt.value, tt.currency, err)
+			}
+			if got != New(tt.want, tt.currency) {
+				t.Errorf("Parse(%q, %q) = %+v, want %d", tt.value, tt.currency, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{New(1250, constants.CurrencyUSD), "12.50"},
+		{New(5, constants.CurrencyUSD), "0.05"},
+		{New(0, constants.CurrencyUSD), "0.00"},
+		{New(-325, constants.CurrencyUSD), "-3.25"},
+		{New(-5, constants.CurrencyUSD), "-0.05"},
+		{New(1, constants.CurrencyBTC), "0.00000001"},
+		{New(150000000, constants.CurrencyBTC), "1.50000000"},
+		{New(500000, constants.CurrencyIRR), "500000"},
+		{New(-20, constants.CurrencyIRT), "-20"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.money.String(); got != tt.want {
+				t.Errorf("%+v.String() = %q, want %q", tt.money, got, tt.want)
+			}
+			if got := tt.money.Decimal().String(); got != tt.want {
+				t.Errorf("%+v.Decimal() = %q, want %q", tt.money, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStringParseRoundTrip(t *testing.T) {
+	for _, currency := range Currencies() {
+		for _, amount := range []int64{0, 1, 7, 99, 100, 123456789, -42} {
+			m := New(amount, currency)
+			got, err := Parse(m.String(), currency)
+			if err != nil {
+				t.Fatalf("Parse(%q, %q) error = %v", m.String(), currency, err)
+			}
+			if got != m {
+				t.Errorf("Parse(%q, %q) = %+v, want %+v", m.String(), currency, got, m)
+			}
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	if got, want := New(1250, constants.CurrencyUSD).Format(), "12.50 USD"; got != want {
+		t.Errorf("Format() = %q, want %q", got, want)
+	}
+}
+
+func TestExponent(t *testing.T) {
+	tests := []struct {
+		currency string
+		want     int
+		err      error
+	}{
+		{constants.CurrencyUSD, 2, nil},
+		{constants.CurrencyIRR, 0, nil},
+		{constants.CurrencyIRT, 0, nil},
+		{constants.CurrencyBTC, 8, nil},
+		{"eur", 0, ErrUnknownCurrency},
+		{"", 0, ErrUnknownCurrency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.currency, func(t *testing.T) {
+			got, err := Exponent(tt.currency)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Exponent(%q) error = %v, want %v", tt.currency, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("Exponent(%q) = %d, want %d", tt.currency, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCurrenciesSorted(t *testing.T) {
+	currencies := Currencies()
+	if len(currencies) != len(exponents) {
+		t.Fatalf("Currencies() returned %d currencies, want %d", len(currencies), len(exponents))
+	}
+	for i := 1; i < len(currencies); i++ {
+		if currencies[i-1] >= currencies[i] {
+			t.Errorf("Currencies() not sorted: %v", currencies)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"vinak/pkg/money"
+)
+
+var (
+	ErrUnknownGateway   = errors.New("unknown payment gateway")
+	ErrInvalidSignature = errors.New("invalid notification signature")
+	// ErrUnsupportedNotification is returned for notification types a gateway ignores
+	ErrUnsupportedNotification = errors.New("unsupported notification type")
+	// ErrPartialRefundUnsupported is returned by refunders that can only reverse a whole payment
+	ErrPartialRefundUnsupported = errors.New("gateway only supports full refunds")
+)
+
+// Gateway is implemented by every payment provider donations can be routed through.
+// Statuses returned by a gateway are always one of the constants.PaymentStatus* values.
+type Gateway interface {
+	Name() string
+	SupportedCurrencies() []string
+	CreatePayment(params CreateParams) (*CreateResult, error)
+	VerifyPayment(params VerifyParams) (*VerifyResult, error)
+	GetPaymentStatus(reference string) (*StatusResult, error)
+}
+
+type CreateParams struct {
+	OrderID     string
+	Amount      money.Money
+	Description string
+	Email       string
+}
+
+type CreateResult struct {
+	// Reference is the gateway-side identifier callbacks are matched against
+	Reference  string
+	PaymentURL string
+	// Charged is the amount actually sent to the gateway when it differs from the
+	// requested one, e.g. after a Rial/Toman conversion
+	Charged money.Money
+	// Details holds gateway specific fields returned to the client as-is
+	Details map[string]interface{}
+}
+
+type VerifyParams struct {
+	Reference string
+	// Amount is the charged amount, see CreateResult.Charged
+	Amount money.Money
+}
+
+type VerifyResult struct {
+	Status        string
+	TransactionID string
+}
+
+type StatusResult struct {
+	Status        string
+	GatewayStatus string
+	Details       map[string]interface{}
+}
+
+// NotificationParser is implemented by gateways that push signed server-to-server
+// notifications. ParseNotification must return ErrInvalidSignature for unsigned or
+// tampered payloads.
+type NotificationParser interface {
+	ParseNotification(header http.Header, body []byte) (*Notification, error)
+}
+
+// Notification identifies the payment by Reference or, for events that only carry the
+// settled transaction (e.g. refunds), by TransactionID.
+type Notification struct {
+	Reference     string
+	TransactionID string
+	Status        string
+	GatewayStatus string
+	// Verify is set when the payer approved the payment and it still has to be
+	// verified or captured through Gateway.VerifyPayment before it completes.
+	Verify bool
+	// Refund is set for refunds reported by the gateway, which may be partial
+	Refund *RefundNotice
+	Data   map[string]interface{}
+}
+
+type RefundNotice struct {
+	Reference string
+	Amount    money.Money
+}
+
+// Refunder is implemented by gateways that can return money to the donor.
+type Refunder interface {
+	RefundPayment(params RefundParams) (*RefundResult, error)
+}
+
+type RefundParams struct {
+	Reference     string
+	TransactionID string
+	// Amount is in the charged currency, see CreateResult.Charged
+	Amount money.Money
+	// Full is set when Amount is everything that was charged
+	Full   bool
+	Reason string
+}
+
+type RefundResult struct {
+	// Reference is the gateway-side refund identifier, if the gateway issues one
+	Reference string
+	// Status is one of the constants.RefundStatus* values. Pending refunds are settled
+	// later by a gateway notification.
+	Status string
+}
+
+// UnsupportedRefundError is returned by CheckRefunder for gateways that can't return money
+// through their API. The donor is paid back by hand and the refund recorded as manual.
+type UnsupportedRefundError struct {
+	Gateway string
+}
+
+func (e *UnsupportedRefundError) Error() string {
+	gateway := strings.ToUpper(e.Gateway[:1]) + e.Gateway[1:]
+	return fmt.Sprintf("%s can't issue refunds, return the funds yourself and record the refund as manual", gateway)
+}
+
+// CheckRefunder returns the gateway's Refunder, or an *UnsupportedRefundError if it has none.
+func CheckRefunder(gateway Gateway) (Refunder, error) {
+	refunder, ok := gateway.(Refunder)
+	if !ok {
+		return nil, &UnsupportedRefundError{Gateway: gateway.Name()}
+	}
+	return refunder, nil
+}
+
+type UnsupportedCurrencyError struct {
+	Gateway   string
+	Supported []string
+}
+
+func (e *UnsupportedCurrencyError) Error() string {
+	supported := strings.ToUpper(strings.Join(e.Supported, ", "))
+	return fmt.Sprintf("%s only supports %s currency", strings.ToUpper(e.Gateway[:1])+e.Gateway[1:], supported)
+}
+
+// CheckCurrency returns an *UnsupportedCurrencyError if the gateway can't charge in currency.
+func CheckCurrency(gateway Gateway, currency string) error {
+	for _, supported := range gateway.SupportedCurrencies() {
+		if supported == currency {
+			return nil
+		}
+	}
+	return &UnsupportedCurrencyError{
+		Gateway:   gateway.Name(),
+		Supported: gateway.SupportedCurrencies(),
+	}
+}
+
+type Registry struct {
+	gateways map[string]Gateway
+}
+
+func NewRegistry(gateways ...Gateway) *Registry {
+	registry := &Registry{
+		gateways: make(map[string]Gateway),
+	}
+	for _, gateway := range gateways {
+		registry.Register(gateway)
+	}
+	return registry
+}
+
+func (r *Registry) Register(gateway Gateway) {
+	r.gateways[gateway.Name()] = gateway
+}
+
+func (r *Registry) Get(name string) (Gateway, error) {
+	gateway, ok := r.gateways[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownGateway, name)
+	}
+	return gateway, nil
+}
+
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.gateways))
+	for name := range r.gateways {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -2,45 +2,55 @@ package payment
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
 )
 
 type NowPaymentsService struct {
-	apiKey string
+	apiKey    string
+	ipnSecret string
 }
 
-func NewNowPaymentsService(apiKey string) *NowPaymentsService {
+func NewNowPaymentsService(apiKey, ipnSecret string) *NowPaymentsService {
 	return &NowPaymentsService{
-		apiKey: apiKey,
+		apiKey:    apiKey,
+		ipnSecret: ipnSecret,
 	}
 }
 
 type NowPaymentsPaymentRequest struct {
-	PriceAmount      float64 `json:"price_amount"`
-	PriceCurrency    string  `json:"price_currency"`
-	PayCurrency      string  `json:"pay_currency"`
-	OrderID          string  `json:"order_id"`
-	OrderDescription string  `json:"order_description"`
-	IPNCallbackURL   string  `json:"ipn_callback_url"`
-	SuccessURL       string  `json:"success_url"`
-	CancelURL        string  `json:"cancel_url"`
+	PriceAmount      json.Number `json:"price_amount"`
+	PriceCurrency    string      `json:"price_currency"`
+	PayCurrency      string      `json:"pay_currency"`
+	OrderID          string      `json:"order_id"`
+	OrderDescription string      `json:"order_description"`
+	IPNCallbackURL   string      `json:"ipn_callback_url"`
+	SuccessURL       string      `json:"success_url"`
+	CancelURL        string      `json:"cancel_url"`
 }
 
 type NowPaymentsPaymentResponse struct {
-	PaymentID        string  `json:"payment_id"`
-	PaymentStatus    string  `json:"payment_status"`
-	PayAddress       string  `json:"pay_address"`
-	PriceAmount      float64 `json:"price_amount"`
-	PriceCurrency    string  `json:"price_currency"`
-	PayAmount        float64 `json:"pay_amount"`
-	PayCurrency      string  `json:"pay_currency"`
-	OrderID          string  `json:"order_id"`
-	OrderDescription string  `json:"order_description"`
-	CreatedAt        string  `json:"created_at"`
-	UpdatedAt        string  `json:"updated_at"`
+	// PaymentID is a string when a payment is created and a number when it is read back
+	PaymentID        json.Number `json:"payment_id"`
+	PaymentStatus    string      `json:"payment_status"`
+	PayAddress       string      `json:"pay_address"`
+	PriceAmount      float64     `json:"price_amount"`
+	PriceCurrency    string      `json:"price_currency"`
+	PayAmount        float64     `json:"pay_amount"`
+	ActuallyPaid     float64     `json:"actually_paid"`
+	PayCurrency      string      `json:"pay_currency"`
+	OrderID          string      `json:"order_id"`
+	OrderDescription string      `json:"order_description"`
+	CreatedAt        string      `json:"created_at"`
+	UpdatedAt        string      `json:"updated_at"`
 }
 
 type NowPaymentsErrorResponse struct {
@@ -48,11 +58,11 @@ type NowPaymentsErrorResponse struct {
 	Code    int    `json:"code"`
 }
 
-func (s *NowPaymentsService) CreatePayment(priceAmount float64, priceCurrency, orderID, orderDescription, callbackURL string) (*NowPaymentsPaymentResponse, error) {
+func (s *NowPaymentsService) CreatePayment(price money.Money, orderID, orderDescription, callbackURL string) (*NowPaymentsPaymentResponse, error) {
 	req := NowPaymentsPaymentRequest{
-		PriceAmount:      priceAmount,
-		PriceCurrency:    priceCurrency,
-		PayCurrency:      "btc", // Default to BTC
+		PriceAmount:      price.Decimal(),
+		PriceCurrency:    price.Currency,
+		PayCurrency:      constants.NowPaymentsDefaultPayCurrency,
 		OrderID:          orderID,
 		OrderDescription: orderDescription,
 		IPNCallbackURL:   callbackURL,
@@ -140,3 +150,181 @@ func (s *NowPaymentsService) GetPaymentStatus(paymentID string) (*NowPaymentsPay
 
 	return &paymentResp, nil
 }
+
+// VerifyIPNSignature checks the x-nowpayments-sig header, which is the HMAC-SHA512 of
+// the body re-encoded with its keys sorted, keyed with the IPN secret.
+func (s *NowPaymentsService) VerifyIPNSignature(body []byte, signature string) error {
+	if s.ipnSecret == "" || signature == "" {
+		return ErrInvalidSignature
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var payload interface{}
+	if err := decoder.Decode(&payload); err != nil {
+		return fmt.Errorf("failed to decode IPN body: %v", err)
+	}
+
+	// encoding/json writes map keys in sorted order, matching the signer
+	var sorted bytes.Buffer
+	encoder := json.NewEncoder(&sorted)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(payload); err != nil {
+		return fmt.Errorf("failed to encode IPN body: %v", err)
+	}
+
+	mac := hmac.New(sha512.New, []byte(s.ipnSecret))
+	mac.Write(bytes.TrimSuffix(sorted.Bytes(), []byte("\n")))
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+type NowPaymentsIPN struct {
+	PaymentID     json.Number `json:"payment_id"`
+	PaymentStatus string      `json:"payment_status"`
+	PayAddress    string      `json:"pay_address"`
+	PriceAmount   float64     `json:"price_amount"`
+	PriceCurrency string      `json:"price_currency"`
+	PayAmount     float64     `json:"pay_amount"`
+	ActuallyPaid  float64     `json:"actually_paid"`
+	PayCurrency   string      `json:"pay_currency"`
+	OrderID       string      `json:"order_id"`
+}
+
+// NowPaymentsGateway doesn't implement Refunder: NowPayments has no API to refund a payment,
+// so crypto is sent back from the payout wallet and the refund recorded as manual.
+type NowPaymentsGateway struct {
+	service           *NowPaymentsService
+	callbackURL       string
+	completedStatuses map[string]bool
+}
+
+// NewNowPaymentsGateway creates the gateway. A payment counts as completed once NowPayments
+// reports "finished" or any of the extra completedStatuses (e.g. "confirmed", "partially_paid").
+func NewNowPaymentsGateway(service *NowPaymentsService, callbackURL string, completedStatuses []string) *NowPaymentsGateway {
+	completed := map[string]bool{"finished": true}
+	for _, status := range completedStatuses {
+		completed[status] = true
+	}
+
+	return &NowPaymentsGateway{
+		service:           service,
+		callbackURL:       callbackURL,
+		completedStatuses: completed,
+	}
+}
+
+func (g *NowPaymentsGateway) Name() string {
+	return constants.PaymentGatewayNowPayments
+}
+
+func (g *NowPaymentsGateway) SupportedCurrencies() []string {
+	return []string{constants.CurrencyUSD, constants.CurrencyBTC}
+}
+
+func (g *NowPaymentsGateway) CreatePayment(params CreateParams) (*CreateResult, error) {
+	resp, err := g.service.CreatePayment(
+		params.Amount,
+		params.OrderID,
+		params.Description,
+		g.callbackURL,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreateResult{
+		Reference: resp.PaymentID.String(),
+		Details: map[string]interface{}{
+			"payment_status":    resp.PaymentStatus,
+			"pay_address":       resp.PayAddress,
+			"price_amount":      resp.PriceAmount,
+			"price_currency":    resp.PriceCurrency,
+			"pay_amount":        resp.PayAmount,
+			"pay_currency":      resp.PayCurrency,
+			"order_id":          resp.OrderID,
+			"order_description": resp.OrderDescription,
+			"created_at":        resp.CreatedAt,
+		},
+	}, nil
+}
+
+// VerifyPayment re-reads the payment from NowPayments since there is nothing to capture
+func (g *NowPaymentsGateway) VerifyPayment(params VerifyParams) (*VerifyResult, error) {
+	status, err := g.GetPaymentStatus(params.Reference)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VerifyResult{
+		Status:        status.Status,
+		TransactionID: params.Reference,
+	}, nil
+}
+
+func (g *NowPaymentsGateway) GetPaymentStatus(reference string) (*StatusResult, error) {
+	resp, err := g.service.GetPaymentStatus(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatusResult{
+		Status:        g.mapStatus(resp.PaymentStatus),
+		GatewayStatus: resp.PaymentStatus,
+		Details: map[string]interface{}{
+			"pay_address":   resp.PayAddress,
+			"pay_amount":    resp.PayAmount,
+			"pay_currency":  resp.PayCurrency,
+			"actually_paid": resp.ActuallyPaid,
+		},
+	}, nil
+}
+
+func (g *NowPaymentsGateway) ParseNotification(header http.Header, body []byte) (*Notification, error) {
+	if err := g.service.VerifyIPNSignature(body, header.Get("x-nowpayments-sig")); err != nil {
+		return nil, err
+	}
+
+	var ipn NowPaymentsIPN
+	if err := json.Unmarshal(body, &ipn); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal IPN: %v", err)
+	}
+
+	return &Notification{
+		Reference:     ipn.PaymentID.String(),
+		Status:        g.mapStatus(ipn.PaymentStatus),
+		GatewayStatus: ipn.PaymentStatus,
+		Data: map[string]interface{}{
+			"payment_id":    ipn.PaymentID.String(),
+			"status":        ipn.PaymentStatus,
+			"pay_amount":    ipn.PayAmount,
+			"actually_paid": ipn.ActuallyPaid,
+			"pay_currency":  ipn.PayCurrency,
+		},
+	}, nil
+}
+
+func (g *NowPaymentsGateway) mapStatus(status string) string {
+	if g.completedStatuses[status] {
+		return constants.PaymentStatusCompleted
+	}
+
+	switch status {
+	case "confirming", "confirmed", "sending", "partially_paid":
+		return constants.PaymentStatusAwaitingConfirmation
+	case "failed":
+		return constants.PaymentStatusFailed
+	case "expired":
+		return constants.PaymentStatusExpired
+	case "refunded":
+		return constants.PaymentStatusRefunded
+	default:
+		return constants.PaymentStatusPending
+	}
+}
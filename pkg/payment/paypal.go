@@ -5,21 +5,30 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
+	"strings"
+	"sync"
+	"time"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
 )
 
 type PayPalService struct {
 	clientID     string
 	clientSecret string
 	mode         string
-	accessToken  string
+	webhookID    string
+
+	mu             sync.Mutex
+	accessToken    string
+	tokenExpiresAt time.Time
 }
 
-func NewPayPalService(clientID, clientSecret, mode string) (*PayPalService, error) {
+func NewPayPalService(clientID, clientSecret, mode, webhookID string) (*PayPalService, error) {
 	service := &PayPalService{
 		clientID:     clientID,
 		clientSecret: clientSecret,
 		mode:         mode,
+		webhookID:    webhookID,
 	}
 
 	// Get access token
@@ -48,12 +57,29 @@ func (s *PayPalService) getAccessToken() error {
 
 	var result struct {
 		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return err
 	}
 
 	s.accessToken = result.AccessToken
+	s.tokenExpiresAt = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	return nil
+}
+
+// authorize sets the bearer token on request, refreshing it shortly before it expires
+func (s *PayPalService) authorize(request *http.Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Now().After(s.tokenExpiresAt.Add(-time.Minute)) {
+		if err := s.getAccessToken(); err != nil {
+			return err
+		}
+	}
+
+	request.Header.Set("Authorization", "Bearer "+s.accessToken)
 	return nil
 }
 
@@ -97,21 +123,21 @@ type Link struct {
 	Method string `json:"method"`
 }
 
-func (s *PayPalService) CreateOrder(amount float64, currency, description string) (*PayPalOrderResponse, error) {
+func (s *PayPalService) CreateOrder(amount money.Money, description, returnURL, cancelURL string) (*PayPalOrderResponse, error) {
 	req := PayPalOrderRequest{
-		Intent: "CAPTURE",
+		Intent: constants.PayPalIntentCapture,
 		PurchaseUnits: []PurchaseUnit{
 			{
 				Amount: Amount{
-					CurrencyCode: currency,
-					Value:        fmt.Sprintf("%.2f", amount),
+					CurrencyCode: strings.ToUpper(amount.Currency),
+					Value:        amount.String(),
 				},
 				Description: description,
 			},
 		},
 		ApplicationContext: ApplicationContext{
-			ReturnURL: os.Getenv("PAYPAL_RETURN_URL"),
-			CancelURL: os.Getenv("PAYPAL_CANCEL_URL"),
+			ReturnURL: returnURL,
+			CancelURL: cancelURL,
 		},
 	}
 
@@ -126,7 +152,9 @@ func (s *PayPalService) CreateOrder(amount float64, currency, description string
 		return nil, err
 	}
 
-	request.Header.Set("Authorization", "Bearer "+s.accessToken)
+	if err := s.authorize(request); err != nil {
+		return nil, err
+	}
 	request.Header.Set("Content-Type", "application/json")
 
 	response, err := client.Do(request)
@@ -147,14 +175,186 @@ func (s *PayPalService) CreateOrder(amount float64, currency, description string
 	return &orderResp, nil
 }
 
-func (s *PayPalService) CaptureOrder(orderID string) error {
+type PayPalCaptureResponse struct {
+	ID            string `json:"id"`
+	Status        string `json:"status"`
+	PurchaseUnits []struct {
+		Payments struct {
+			Captures []PayPalCapture `json:"captures"`
+		} `json:"payments"`
+	} `json:"purchase_units"`
+}
+
+type PayPalCapture struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Amount Amount `json:"amount"`
+}
+
+// CaptureID returns the id of the first capture, which refunds are issued against
+func (r *PayPalCaptureResponse) CaptureID() string {
+	for _, unit := range r.PurchaseUnits {
+		for _, capture := range unit.Payments.Captures {
+			return capture.ID
+		}
+	}
+	return ""
+}
+
+func (s *PayPalService) CaptureOrder(orderID string) (*PayPalCaptureResponse, error) {
 	client := &http.Client{}
 	request, err := http.NewRequest("POST", s.getBaseURL()+"/v2/checkout/orders/"+orderID+"/capture", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authorize(request); err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("paypal API returned status code: %d", response.StatusCode)
+	}
+
+	var captureResp PayPalCaptureResponse
+	if err := json.NewDecoder(response.Body).Decode(&captureResp); err != nil {
+		return nil, err
+	}
+
+	return &captureResp, nil
+}
+
+func (s *PayPalService) GetOrder(orderID string) (*PayPalCaptureResponse, error) {
+	client := &http.Client{}
+	request, err := http.NewRequest("GET", s.getBaseURL()+"/v2/checkout/orders/"+orderID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authorize(request); err != nil {
+		return nil, err
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("paypal API returned status code: %d", response.StatusCode)
+	}
+
+	var orderResp PayPalCaptureResponse
+	if err := json.NewDecoder(response.Body).Decode(&orderResp); err != nil {
+		return nil, err
+	}
+
+	return &orderResp, nil
+}
+
+type PayPalRefundRequest struct {
+	Amount      *Amount `json:"amount,omitempty"`
+	NoteToPayer string  `json:"note_to_payer,omitempty"`
+}
+
+type PayPalRefundResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// RefundCapture refunds a settled capture. A nil amount refunds whatever is left on it.
+func (s *PayPalService) RefundCapture(captureID string, amount *money.Money, note string) (*PayPalRefundResponse, error) {
+	req := PayPalRefundRequest{NoteToPayer: note}
+	if amount != nil {
+		req.Amount = &Amount{
+			CurrencyCode: strings.ToUpper(amount.Currency),
+			Value:        amount.String(),
+		}
+	}
+
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{}
+	request, err := http.NewRequest("POST", s.getBaseURL()+"/v2/payments/captures/"+captureID+"/refund", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authorize(request); err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("paypal API returned status code: %d", response.StatusCode)
+	}
+
+	var refundResp PayPalRefundResponse
+	if err := json.NewDecoder(response.Body).Decode(&refundResp); err != nil {
+		return nil, err
+	}
+
+	return &refundResp, nil
+}
+
+type PayPalWebhookVerificationRequest struct {
+	AuthAlgo         string          `json:"auth_algo"`
+	CertURL          string          `json:"cert_url"`
+	TransmissionID   string          `json:"transmission_id"`
+	TransmissionSig  string          `json:"transmission_sig"`
+	TransmissionTime string          `json:"transmission_time"`
+	WebhookID        string          `json:"webhook_id"`
+	WebhookEvent     json.RawMessage `json:"webhook_event"`
+}
+
+// VerifyWebhookSignature asks PayPal to validate the transmission headers of a webhook
+// delivery against the configured webhook id.
+func (s *PayPalService) VerifyWebhookSignature(header http.Header, body []byte) error {
+	if s.webhookID == "" || header.Get("PAYPAL-TRANSMISSION-SIG") == "" {
+		return ErrInvalidSignature
+	}
+
+	req := PayPalWebhookVerificationRequest{
+		AuthAlgo:         header.Get("PAYPAL-AUTH-ALGO"),
+		CertURL:          header.Get("PAYPAL-CERT-URL"),
+		TransmissionID:   header.Get("PAYPAL-TRANSMISSION-ID"),
+		TransmissionSig:  header.Get("PAYPAL-TRANSMISSION-SIG"),
+		TransmissionTime: header.Get("PAYPAL-TRANSMISSION-TIME"),
+		WebhookID:        s.webhookID,
+		WebhookEvent:     body,
+	}
+
+	reqBody, err := json.Marshal(req)
 	if err != nil {
 		return err
 	}
 
-	request.Header.Set("Authorization", "Bearer "+s.accessToken)
+	client := &http.Client{}
+	request, err := http.NewRequest("POST", s.getBaseURL()+"/v1/notifications/verify-webhook-signature", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+
+	if err := s.authorize(request); err != nil {
+		return err
+	}
 	request.Header.Set("Content-Type", "application/json")
 
 	response, err := client.Do(request)
@@ -163,9 +363,218 @@ func (s *PayPalService) CaptureOrder(orderID string) error {
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusCreated {
+	if response.StatusCode != http.StatusOK {
 		return fmt.Errorf("paypal API returned status code: %d", response.StatusCode)
 	}
 
+	var result struct {
+		VerificationStatus string `json:"verification_status"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	if result.VerificationStatus != "SUCCESS" {
+		return ErrInvalidSignature
+	}
+
 	return nil
 }
+
+type PayPalWebhookEvent struct {
+	ID        string          `json:"id"`
+	EventType string          `json:"event_type"`
+	Resource  json.RawMessage `json:"resource"`
+}
+
+// PayPalWebhookResource covers the fields used from both order and capture/refund resources
+type PayPalWebhookResource struct {
+	ID                string `json:"id"`
+	Status            string `json:"status"`
+	Amount            Amount `json:"amount"`
+	Links             []Link `json:"links"`
+	SupplementaryData struct {
+		RelatedIDs struct {
+			OrderID string `json:"order_id"`
+		} `json:"related_ids"`
+	} `json:"supplementary_data"`
+}
+
+// parentCaptureID returns the capture a refund resource belongs to
+func (r *PayPalWebhookResource) parentCaptureID() string {
+	for _, link := range r.Links {
+		if link.Rel == "up" {
+			return link.Href[strings.LastIndex(link.Href, "/")+1:]
+		}
+	}
+	return ""
+}
+
+type PayPalGateway struct {
+	service   *PayPalService
+	returnURL string
+	cancelURL string
+}
+
+func NewPayPalGateway(service *PayPalService, returnURL, cancelURL string) *PayPalGateway {
+	return &PayPalGateway{
+		service:   service,
+		returnURL: returnURL,
+		cancelURL: cancelURL,
+	}
+}
+
+func (g *PayPalGateway) Name() string {
+	return constants.PaymentGatewayPayPal
+}
+
+func (g *PayPalGateway) SupportedCurrencies() []string {
+	return []string{constants.CurrencyUSD}
+}
+
+func (g *PayPalGateway) CreatePayment(params CreateParams) (*CreateResult, error) {
+	order, err := g.service.CreateOrder(params.Amount, params.Description, g.returnURL, g.cancelURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var approvalURL string
+	for _, link := range order.Links {
+		if link.Rel == "approve" {
+			approvalURL = link.Href
+			break
+		}
+	}
+
+	return &CreateResult{
+		Reference:  order.ID,
+		PaymentURL: approvalURL,
+	}, nil
+}
+
+func (g *PayPalGateway) VerifyPayment(params VerifyParams) (*VerifyResult, error) {
+	capture, err := g.service.CaptureOrder(params.Reference)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VerifyResult{
+		Status:        paypalStatus(capture.Status),
+		TransactionID: capture.CaptureID(),
+	}, nil
+}
+
+func (g *PayPalGateway) GetPaymentStatus(reference string) (*StatusResult, error) {
+	order, err := g.service.GetOrder(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatusResult{
+		Status:        paypalStatus(order.Status),
+		GatewayStatus: order.Status,
+	}, nil
+}
+
+func (g *PayPalGateway) ParseNotification(header http.Header, body []byte) (*Notification, error) {
+	if err := g.service.VerifyWebhookSignature(header, body); err != nil {
+		return nil, err
+	}
+
+	var event PayPalWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhook event: %v", err)
+	}
+
+	var resource PayPalWebhookResource
+	if err := json.Unmarshal(event.Resource, &resource); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhook resource: %v", err)
+	}
+
+	notification := &Notification{
+		Status:        constants.PaymentStatusPending,
+		GatewayStatus: event.EventType,
+		Data: map[string]interface{}{
+			"event_id":    event.ID,
+			"event_type":  event.EventType,
+			"resource_id": resource.ID,
+			"status":      resource.Status,
+		},
+	}
+
+	switch event.EventType {
+	case "CHECKOUT.ORDER.APPROVED":
+		notification.Reference = resource.ID
+		notification.Status = constants.PaymentStatusAwaitingConfirmation
+		notification.Verify = true
+	case "PAYMENT.CAPTURE.COMPLETED":
+		notification.Reference = resource.SupplementaryData.RelatedIDs.OrderID
+		notification.TransactionID = resource.ID
+		notification.Status = constants.PaymentStatusCompleted
+	case "PAYMENT.CAPTURE.DENIED":
+		notification.Reference = resource.SupplementaryData.RelatedIDs.OrderID
+		notification.TransactionID = resource.ID
+		notification.Status = constants.PaymentStatusFailed
+	case "PAYMENT.CAPTURE.REFUNDED":
+		// The resource is the refund itself, which may only cover part of the capture
+		currency := strings.ToLower(resource.Amount.CurrencyCode)
+		amount, err := money.Parse(resource.Amount.Value, currency)
+		if err != nil {
+			return nil, fmt.Errorf("invalid refund amount: %v", err)
+		}
+		notification.TransactionID = resource.parentCaptureID()
+		notification.Status = constants.PaymentStatusRefunded
+		notification.Refund = &RefundNotice{
+			Reference: resource.ID,
+			Amount:    amount,
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedNotification, event.EventType)
+	}
+
+	return notification, nil
+}
+
+// RefundPayment refunds the capture recorded as the payment's transaction id.
+func (g *PayPalGateway) RefundPayment(params RefundParams) (*RefundResult, error) {
+	if params.TransactionID == "" {
+		return nil, fmt.Errorf("paypal order %s has no capture to refund", params.Reference)
+	}
+
+	var amount *money.Money
+	if !params.Full {
+		amount = &params.Amount
+	}
+
+	refund, err := g.service.RefundCapture(params.TransactionID, amount, params.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	status := constants.RefundStatusPending
+	switch refund.Status {
+	case "COMPLETED":
+		status = constants.RefundStatusCompleted
+	case "CANCELLED", "FAILED":
+		status = constants.RefundStatusFailed
+	}
+
+	return &RefundResult{
+		Reference: refund.ID,
+		Status:    status,
+	}, nil
+}
+
+func paypalStatus(status string) string {
+	switch status {
+	case "COMPLETED":
+		return constants.PaymentStatusCompleted
+	case "APPROVED":
+		// Approved by the payer but not yet captured
+		return constants.PaymentStatusAwaitingConfirmation
+	case "VOIDED":
+		return constants.PaymentStatusFailed
+	default:
+		return constants.PaymentStatusPending
+	}
+}
@@ -3,11 +3,29 @@ package payment
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
 )
 
+// ErrZarinpalRejected is returned when Zarinpal answers a verification with a code that
+// settles the payment as failed, as opposed to not answering at all
+var ErrZarinpalRejected = errors.New("zarinpal rejected the payment")
+
+// zarinpalRejectionCodes are the verification errors that mean the payment will never
+// go through: the donor didn't pay (-51), paid another amount (-50) or the authority
+// doesn't belong to the payment (-53, -54). Other errors may clear up on a retry.
+var zarinpalRejectionCodes = map[int]bool{
+	-50: true,
+	-51: true,
+	-53: true,
+	-54: true,
+}
+
 type ZarinpalService struct {
 	MerchantID string
 	Sandbox    bool
@@ -30,6 +48,7 @@ func (s *ZarinpalService) getBaseURL() string {
 type PaymentRequest struct {
 	MerchantID  string          `json:"merchant_id"`
 	Amount      int             `json:"amount"`
+	Currency    string          `json:"currency"`
 	CallbackURL string          `json:"callback_url"`
 	Description string          `json:"description"`
 	Metadata    PaymentMetadata `json:"metadata"`
@@ -79,10 +98,11 @@ type VerificationResponse struct {
 	} `json:"errors"`
 }
 
-func (s *ZarinpalService) CreatePayment(amount int, callbackURL, description, email, mobile string) (string, string, error) {
+func (s *ZarinpalService) CreatePayment(amount int, currency, callbackURL, description, email, mobile string) (string, string, error) {
 	req := PaymentRequest{
 		MerchantID:  s.MerchantID,
 		Amount:      amount,
+		Currency:    currency,
 		CallbackURL: callbackURL,
 		Description: description,
 		Metadata: PaymentMetadata{
@@ -181,6 +201,9 @@ func (s *ZarinpalService) VerifyPayment(amount int, authority string) (bool, str
 		return false, "", fmt.Errorf("failed to unmarshal response: %v, body: %s", err, string(body))
 	}
 
+	if zarinpalRejectionCodes[verifyResp.Errors.Code] {
+		return false, "", fmt.Errorf("%w with code: %d, message: %s", ErrZarinpalRejected, verifyResp.Errors.Code, verifyResp.Errors.Message)
+	}
 	if verifyResp.Errors.Code != 0 {
 		return false, "", fmt.Errorf("payment verification failed with code: %d, message: %s", verifyResp.Errors.Code, verifyResp.Errors.Message)
 	}
@@ -191,3 +214,256 @@ func (s *ZarinpalService) VerifyPayment(amount int, authority string) (bool, str
 
 	return true, fmt.Sprintf("%d", verifyResp.Data.RefID), nil
 }
+
+type InquiryRequest struct {
+	MerchantID string `json:"merchant_id"`
+	Authority  string `json:"authority"`
+}
+
+type InquiryResponse struct {
+	Data struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"data"`
+	Errors struct {
+		Message     string   `json:"message"`
+		Code        int      `json:"code"`
+		Validations []string `json:"validations"`
+	} `json:"errors"`
+}
+
+func (s *ZarinpalService) InquirePayment(authority string) (string, error) {
+	req := InquiryRequest{
+		MerchantID: s.MerchantID,
+		Authority:  authority,
+	}
+
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	client := &http.Client{}
+	request, err := http.NewRequest("POST", s.getBaseURL()+"/payment/inquiry.json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	var inquiryResp InquiryResponse
+	if err := json.Unmarshal(body, &inquiryResp); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %v, body: %s", err, string(body))
+	}
+
+	if inquiryResp.Errors.Code != 0 {
+		return "", fmt.Errorf("payment inquiry failed with code: %d, message: %s", inquiryResp.Errors.Code, inquiryResp.Errors.Message)
+	}
+
+	return inquiryResp.Data.Status, nil
+}
+
+type ReverseRequest struct {
+	MerchantID string `json:"merchant_id"`
+	Authority  string `json:"authority"`
+}
+
+type ReverseResponse struct {
+	Data struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"data"`
+	Errors struct {
+		Message     string   `json:"message"`
+		Code        int      `json:"code"`
+		Validations []string `json:"validations"`
+	} `json:"errors"`
+}
+
+// ReversePayment returns a verified payment to the payer's card in full. Zarinpal only
+// accepts reversals shortly after verification.
+func (s *ZarinpalService) ReversePayment(authority string) error {
+	req := ReverseRequest{
+		MerchantID: s.MerchantID,
+		Authority:  authority,
+	}
+
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	client := &http.Client{}
+	request, err := http.NewRequest("POST", s.getBaseURL()+"/payment/reverse.json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	var reverseResp ReverseResponse
+	if err := json.Unmarshal(body, &reverseResp); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %v, body: %s", err, string(body))
+	}
+
+	if reverseResp.Errors.Code != 0 {
+		return fmt.Errorf("payment reverse failed with code: %d, message: %s", reverseResp.Errors.Code, reverseResp.Errors.Message)
+	}
+
+	if reverseResp.Data.Code != 100 {
+		return fmt.Errorf("payment reverse failed with code: %d, message: %s", reverseResp.Data.Code, reverseResp.Data.Message)
+	}
+
+	return nil
+}
+
+type ZarinpalGateway struct {
+	service     *ZarinpalService
+	callbackURL string
+	// chargeCurrency is what amounts are sent to Zarinpal in, IRR or IRT
+	chargeCurrency string
+}
+
+func NewZarinpalGateway(service *ZarinpalService, callbackURL, chargeCurrency string) *ZarinpalGateway {
+	return &ZarinpalGateway{
+		service:        service,
+		callbackURL:    callbackURL,
+		chargeCurrency: chargeCurrency,
+	}
+}
+
+func (g *ZarinpalGateway) Name() string {
+	return constants.PaymentGatewayZarinpal
+}
+
+func (g *ZarinpalGateway) SupportedCurrencies() []string {
+	return []string{constants.CurrencyIRR, constants.CurrencyIRT}
+}
+
+// chargeAmount converts a donation into the currency Zarinpal is charged in. This is the
+// only place Rials and Tomans are converted.
+func (g *ZarinpalGateway) chargeAmount(amount money.Money) (money.Money, error) {
+	switch {
+	case amount.Currency == g.chargeCurrency:
+		return amount, nil
+	case amount.Currency == constants.CurrencyIRT && g.chargeCurrency == constants.CurrencyIRR:
+		return money.New(amount.Amount*money.RialsPerToman, constants.CurrencyIRR), nil
+	case amount.Currency == constants.CurrencyIRR && g.chargeCurrency == constants.CurrencyIRT:
+		if amount.Amount%money.RialsPerToman != 0 {
+			return money.Money{}, fmt.Errorf("%w: IRR amounts must be a multiple of %d", money.ErrInvalidAmount, money.RialsPerToman)
+		}
+		return money.New(amount.Amount/money.RialsPerToman, constants.CurrencyIRT), nil
+	default:
+		return money.Money{}, &UnsupportedCurrencyError{Gateway: g.Name(), Supported: g.SupportedCurrencies()}
+	}
+}
+
+func (g *ZarinpalGateway) CreatePayment(params CreateParams) (*CreateResult, error) {
+	charged, err := g.chargeAmount(params.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	paymentURL, authority, err := g.service.CreatePayment(
+		int(charged.Amount),
+		strings.ToUpper(charged.Currency),
+		g.callbackURL,
+		params.Description,
+		params.Email,
+		"",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreateResult{
+		Reference:  authority,
+		PaymentURL: paymentURL,
+		Charged:    charged,
+	}, nil
+}
+
+// VerifyPayment fails the payment only when Zarinpal rejects it. Errors reaching Zarinpal
+// are returned so the payment stays open until it can be verified.
+func (g *ZarinpalGateway) VerifyPayment(params VerifyParams) (*VerifyResult, error) {
+	verified, refID, err := g.service.VerifyPayment(int(params.Amount.Amount), params.Reference)
+	if errors.Is(err, ErrZarinpalRejected) {
+		return &VerifyResult{Status: constants.PaymentStatusFailed}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !verified {
+		return &VerifyResult{Status: constants.PaymentStatusFailed}, nil
+	}
+
+	return &VerifyResult{
+		Status:        constants.PaymentStatusCompleted,
+		TransactionID: refID,
+	}, nil
+}
+
+func (g *ZarinpalGateway) GetPaymentStatus(reference string) (*StatusResult, error) {
+	status, err := g.service.InquirePayment(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatusResult{
+		Status:        zarinpalStatus(status),
+		GatewayStatus: status,
+	}, nil
+}
+
+func (g *ZarinpalGateway) RefundPayment(params RefundParams) (*RefundResult, error) {
+	if !params.Full {
+		return nil, ErrPartialRefundUnsupported
+	}
+
+	if err := g.service.ReversePayment(params.Reference); err != nil {
+		return nil, err
+	}
+
+	return &RefundResult{Status: constants.RefundStatusCompleted}, nil
+}
+
+func zarinpalStatus(status string) string {
+	switch status {
+	case "VERIFIED":
+		return constants.PaymentStatusCompleted
+	case "PAID":
+		// Paid by the donor but not yet verified by us
+		return constants.PaymentStatusAwaitingConfirmation
+	case "FAILED":
+		return constants.PaymentStatusFailed
+	case "REVERSED":
+		return constants.PaymentStatusRefunded
+	default:
+		return constants.PaymentStatusPending
+	}
+}
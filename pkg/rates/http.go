@@ -0,0 +1,71 @@
+package rates
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"vinak/pkg/constants"
+	"vinak/pkg/money"
+)
+
+// HTTPProvider reads rates from a JSON endpoint in the common {"rates": {"IRR": 42000}}
+// shape, quoted as units of each currency per unit of the reference currency, as
+// open.er-api.com and exchangerate.host return them. "{base}" in the URL is replaced by
+// the reference currency.
+type HTTPProvider struct {
+	url    string
+	client *http.Client
+}
+
+type httpRatesResponse struct {
+	Rates map[string]float64 `json:"rates"`
+}
+
+func NewHTTPProvider(url string) *HTTPProvider {
+	return &HTTPProvider{
+		url:    url,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *HTTPProvider) Name() string {
+	return constants.RateProviderHTTP
+}
+
+func (p *HTTPProvider) Rates(reference string, currencies []string) (map[string]float64, error) {
+	resp, err := p.client.Get(strings.ReplaceAll(p.url, "{base}", strings.ToUpper(reference)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch exchange rates: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("exchange rate request failed with status %d", resp.StatusCode)
+	}
+
+	var ratesResp httpRatesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ratesResp); err != nil {
+		return nil, fmt.Errorf("failed to decode exchange rates: %w", err)
+	}
+
+	quotes := make(map[string]float64, len(ratesResp.Rates))
+	for currency, quote := range ratesResp.Rates {
+		quotes[strings.ToLower(currency)] = quote
+	}
+	// Market sources don't list the Toman, but it is a fixed multiple of the Rial
+	if _, ok := quotes[constants.CurrencyIRT]; !ok {
+		if quote, ok := quotes[constants.CurrencyIRR]; ok {
+			quotes[constants.CurrencyIRT] = quote / money.RialsPerToman
+		}
+	}
+
+	rates := make(map[string]float64, len(currencies))
+	for _, currency := range currencies {
+		if quote := quotes[currency]; quote > 0 {
+			rates[currency] = 1 / quote
+		}
+	}
+	return rates, nil
+}
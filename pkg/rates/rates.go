@@ -0,0 +1,39 @@
+package rates
+
+import (
+	"vinak/pkg/constants"
+)
+
+// Provider fetches current exchange rates. A rate is the value of one major unit of a
+// currency in the reference currency, e.g. 0.0000024 for IRR against USD. Currencies
+// the provider has no rate for are left out of the result.
+type Provider interface {
+	Name() string
+	Rates(reference string, currencies []string) (map[string]float64, error)
+}
+
+// StaticProvider serves fixed rates from configuration, for running offline or when no
+// market source covers a currency.
+type StaticProvider struct {
+	rates map[string]float64
+}
+
+func NewStaticProvider(rates map[string]float64) *StaticProvider {
+	return &StaticProvider{
+		rates: rates,
+	}
+}
+
+func (p *StaticProvider) Name() string {
+	return constants.RateProviderStatic
+}
+
+func (p *StaticProvider) Rates(reference string, currencies []string) (map[string]float64, error) {
+	rates := make(map[string]float64, len(currencies))
+	for _, currency := range currencies {
+		if rate, ok := p.rates[currency]; ok {
+			rates[currency] = rate
+		}
+	}
+	return rates, nil
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"vinak/pkg/constants"
+)
+
+var ErrNotFound = errors.New("asset not found in store")
+
+// Location is where a download is delivered from: a local file the API sends itself, or
+// a short-lived URL on a remote store the client is redirected to.
+type Location struct {
+	Path string
+	URL  string
+}
+
+// Store locates album assets by key. fileName is what the downloaded file is saved as.
+type Store interface {
+	Name() string
+	Locate(key, fileName string) (Location, error)
+}
+
+// LocalStore serves assets from a directory on disk.
+type LocalStore struct {
+	root string
+}
+
+func NewLocalStore(root string) *LocalStore {
+	return &LocalStore{
+		root: root,
+	}
+}
+
+func (s *LocalStore) Name() string {
+	return constants.AssetStoreLocal
+}
+
+func (s *LocalStore) Locate(key, fileName string) (Location, error) {
+	// Cleaning the key as an absolute path keeps it from escaping the root
+	path := filepath.Join(s.root, filepath.Clean("/"+key))
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && info.IsDir()) {
+		return Location{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return Location{}, err
+	}
+
+	return Location{Path: path}, nil
+}
@@ -3,13 +3,14 @@ package telegram
 import (
 	"fmt"
 	"time"
+	"vinak/pkg/money"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 type TelegramService struct {
-	bot     *tgbotapi.BotAPI
-	chatID  int64
+	bot    *tgbotapi.BotAPI
+	chatID int64
 }
 
 func NewTelegramService(token string, chatID int64) (*TelegramService, error) {
@@ -24,21 +25,42 @@ func NewTelegramService(token string, chatID int64) (*TelegramService, error) {
 	}, nil
 }
 
-func (s *TelegramService) SendPaymentNotification(name, instagramID string, amount float64, currency string, paymentTime time.Time) error {
+func (s *TelegramService) SendPaymentNotification(name, instagramID, donorMessage string, amount money.Money, paymentTime time.Time) error {
 	message := fmt.Sprintf(
 		"💰 New Payment Received!\n\n"+
-		"👤 Name: %s\n"+
-		"📱 Instagram ID: %s\n"+
-		"💵 Amount: %.2f %s\n"+
-		"⏰ Time: %s",
+			"👤 Name: %s\n"+
+			"📱 Instagram ID: %s\n"+
+			"💵 Amount: %s\n"+
+			"⏰ Time: %s",
 		name,
 		instagramID,
-		amount,
-		currency,
+		amount.Format(),
 		paymentTime.Format("2006-01-02 15:04:05"),
 	)
+	if donorMessage != "" {
+		message += fmt.Sprintf("\n💬 Message: %s", donorMessage)
+	}
+
+	msg := tgbotapi.NewMessage(s.chatID, message)
+	_, err := s.bot.Send(msg)
+	return err
+}
+
+func (s *TelegramService) SendRefundNotification(name, instagramID string, refunded, amount money.Money, refundTime time.Time) error {
+	message := fmt.Sprintf(
+		"↩️ Payment Refunded\n\n"+
+			"👤 Name: %s\n"+
+			"📱 Instagram ID: %s\n"+
+			"💸 Refunded: %s of %s\n"+
+			"⏰ Time: %s",
+		name,
+		instagramID,
+		refunded.Format(),
+		amount.Format(),
+		refundTime.Format("2006-01-02 15:04:05"),
+	)
 
 	msg := tgbotapi.NewMessage(s.chatID, message)
 	_, err := s.bot.Send(msg)
 	return err
-} 
\ No newline at end of file
+}